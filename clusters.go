@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Cluster describes one of the clusters the spec is applied to, with its connection settings and the topic overrides
+type Cluster struct {
+	Name       string     `yaml:"name" json:"name"`
+	Connection Connection `yaml:"connection,omitempty" json:"connection,omitempty"`
+	Topics     []Topic    `yaml:"topics,omitempty" json:"topics,omitempty"`
+}
+
+// clusterName is the cluster of the spec the current run of apply, plan or check is for
+var clusterName string
+
+// lastSummary keeps the counters of the last printed summary
+var lastSummary struct {
+	ok, changed, failed int
+}
+
+// resolveCluster returns the spec for the cluster: its connection settings are merged over the common ones
+// and its topic overrides are applied to the topics
+func resolveCluster(spec Spec, name string) (Spec, error) {
+	if name == "" {
+		if len(spec.Clusters) > 0 {
+			return spec, errors.New("The spec defines clusters " + strings.Join(clusterNames(spec), ", ") + ", please select them with --cluster")
+		}
+		return spec, nil
+	}
+	var cluster *Cluster
+	for i := range spec.Clusters {
+		if spec.Clusters[i].Name == name {
+			cluster = &spec.Clusters[i]
+		}
+	}
+	if cluster == nil {
+		return spec, errors.New("Cluster " + name + " is not defined in the spec")
+	}
+	spec.Connection = mergeConnection(spec.Connection, cluster.Connection)
+	spec.Topics = overrideTopics(spec.Topics, cluster.Topics)
+	spec.Clusters = nil
+	return spec, nil
+}
+
+func clusterNames(spec Spec) []string {
+	var names []string
+	for _, cluster := range spec.Clusters {
+		names = append(names, cluster.Name)
+	}
+	return names
+}
+
+// selectClusters returns the clusters chosen by --cluster, "all" stands for every cluster of the spec
+func selectClusters(spec Spec, selection string) ([]string, error) {
+	if len(spec.Clusters) == 0 {
+		return nil, errors.New("Option --cluster requires the clusters section in the spec")
+	}
+	seen := make(map[string]bool)
+	for _, cluster := range spec.Clusters {
+		if cluster.Name == "" {
+			return nil, errors.New("Cluster name is not defined")
+		}
+		if seen[cluster.Name] {
+			return nil, errors.New("Cluster " + cluster.Name + " is defined more than once")
+		}
+		seen[cluster.Name] = true
+	}
+	if selection == "all" {
+		return clusterNames(spec), nil
+	}
+	var names []string
+	for _, name := range strings.Split(selection, ",") {
+		name = strings.TrimSpace(name)
+		if !seen[name] {
+			return nil, errors.New("Cluster " + name + " is not defined in the spec, available: " + strings.Join(clusterNames(spec), ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// mergeConnection overrides the common connection settings with the ones defined for the cluster.
+// A secret defined for the cluster replaces all the sources of the common secret
+func mergeConnection(base Connection, override Connection) Connection {
+	if override.Password != "" || override.PasswordFile != "" || override.PasswordEnv != "" || override.PasswordCommand != "" {
+		base.Password, base.PasswordFile, base.PasswordEnv, base.PasswordCommand = "", "", "", ""
+	}
+	if override.TLSKeyPassword != "" || override.TLSKeyPasswordFile != "" || override.TLSKeyPasswordEnv != "" || override.TLSKeyPasswordCommand != "" {
+		base.TLSKeyPassword, base.TLSKeyPasswordFile, base.TLSKeyPasswordEnv, base.TLSKeyPasswordCommand = "", "", "", ""
+	}
+	if override.OAuthClientSecret != "" || override.OAuthClientSecretFile != "" || override.OAuthClientSecretEnv != "" || override.OAuthClientSecretCommand != "" {
+		base.OAuthClientSecret, base.OAuthClientSecretFile, base.OAuthClientSecretEnv, base.OAuthClientSecretCommand = "", "", "", ""
+	}
+	merged := reflect.ValueOf(&base).Elem()
+	fields := reflect.ValueOf(override)
+	for i := 0; i < fields.NumField(); i++ {
+		if !fields.Field(i).IsZero() {
+			merged.Field(i).Set(fields.Field(i))
+		}
+	}
+	return base
+}
+
+// overrideTopics applies the cluster overrides to the topics with the same name: the fields defined in the
+// override replace the ones of the topic and the configs are merged. The overrides of unknown topics are added
+func overrideTopics(topics []Topic, overrides []Topic) []Topic {
+	result := make([]Topic, len(topics))
+	index := make(map[string]int)
+	for i, topic := range topics {
+		topic.Configs = copyConfigs(topic.Configs)
+		result[i] = topic
+		index[topic.Name] = i
+	}
+	for _, override := range overrides {
+		i, found := index[override.Name]
+		if !found {
+			override.Configs = copyConfigs(override.Configs)
+			index[override.Name] = len(result)
+			result = append(result, override)
+			continue
+		}
+		topic := &result[i]
+		if override.Partitions > 0 {
+			topic.Partitions = override.Partitions
+		}
+		if override.ReplicationFactor > 0 {
+			topic.ReplicationFactor = override.ReplicationFactor
+		}
+		if override.State != "" {
+			topic.State = override.State
+		}
+		if override.ReplicaAssignment != nil {
+			topic.ReplicaAssignment = override.ReplicaAssignment
+		}
+		if override.RackAware {
+			topic.RackAware = true
+		}
+		if len(override.Configs) > 0 && topic.Configs == nil {
+			topic.Configs = make(map[string]string)
+		}
+		for key, val := range override.Configs {
+			topic.Configs[key] = val
+		}
+	}
+	return result
+}
+
+func copyConfigs(configs map[string]string) map[string]string {
+	if configs == nil {
+		return nil
+	}
+	out := make(map[string]string, len(configs))
+	for key, val := range configs {
+		out[key] = val
+	}
+	return out
+}
+
+// snapshotConnection returns the function restoring the connection settings which the spec of a cluster changes
+func snapshotConnection() func() {
+	settings := []*string{&broker, &protocol, &mechanism, &username, &password, &tlsCA, &tlsCert, &tlsKey,
+		&tlsKeyPassword, &tlsServerName, &kerberosServiceName, &kerberosRealm, &kerberosKeytab, &kerberosCcache,
+		&kerberosConfig, &oauthTokenURL, &oauthClientID, &oauthClientSecret, &kafkaVersion}
+	values := make([]string, len(settings))
+	for i, s := range settings {
+		values[i] = *s
+	}
+	insecure, scopes := tlsInsecure, oauthScopes
+	return func() {
+		for i, s := range settings {
+			*s = values[i]
+		}
+		tlsInsecure, oauthScopes = insecure, scopes
+	}
+}
+
+type clusterResult struct {
+	name                string
+	broker              string
+	ok, changed, failed int
+	err                 string
+}
+
+// forEachCluster parses the spec once and runs the action with it once per cluster selected by --cluster,
+// then prints the per-cluster summary. Without --cluster the action is run once
+func forEachCluster(action func(spec Spec) error) error {
+	spec, err := parseSpecFile()
+	if err != nil {
+		return errors.New("Can't parse spec manifest: " + err.Error())
+	}
+	if clusterSelection == "" {
+		return action(spec)
+	}
+	names, err := selectClusters(spec, clusterSelection)
+	if err != nil {
+		return err
+	}
+
+	var results []clusterResult
+	failed := false
+	for _, name := range names {
+		width := 80 - len(name)
+		if width < 0 {
+			width = 0
+		}
+		fmt.Printf("CLUSTER [%s] %s\n", name, strings.Repeat("*", width))
+		restore := snapshotConnection()
+		clusterName = name
+		lastSummary.ok, lastSummary.changed, lastSummary.failed = 0, 0, 0
+		err := action(spec)
+		result := clusterResult{name: name, broker: broker,
+			ok: lastSummary.ok, changed: lastSummary.changed, failed: lastSummary.failed}
+		if err != nil {
+			failed = true
+			if err.Error() != "" {
+				fmt.Println(err.Error())
+				result.err = err.Error()
+			}
+		}
+		results = append(results, result)
+		clusterName = ""
+		restore()
+		if err != nil && errorStop {
+			break
+		}
+	}
+	printClusterSummary(results)
+	if failed {
+		return errors.New("")
+	}
+	return nil
+}
+
+func printClusterSummary(results []clusterResult) {
+	fmt.Printf("CLUSTERS %s\n", strings.Repeat("*", 79))
+	for _, r := range results {
+		fmt.Printf(" %-12s [%s]", r.name, r.broker)
+		if r.err != "" {
+			fmt.Printf(Error+" error: %s\n"+Default, r.err)
+			continue
+		}
+		printSummaryCounters(r.ok, r.changed, r.failed)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverrideTopics(t *testing.T) {
+	topics := []Topic{
+		{Name: "orders", Partitions: 3, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "1000", "cleanup.policy": "delete"}},
+		{Name: "events", Partitions: 1},
+	}
+	result := overrideTopics(topics, []Topic{
+		{Name: "orders", Partitions: 12, Configs: map[string]string{"retention.ms": "5000"}},
+		{Name: "debug", Partitions: 1},
+	})
+	expected := []Topic{
+		{Name: "orders", Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "5000", "cleanup.policy": "delete"}},
+		{Name: "events", Partitions: 1},
+		{Name: "debug", Partitions: 1},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("overrideTopics failed:\n%+v\n%+v", result, expected)
+	}
+	if topics[0].Partitions != 3 || topics[0].Configs["retention.ms"] != "1000" {
+		t.Errorf("overrideTopics must not change the common topics: %+v", topics[0])
+	}
+}
+
+func TestMergeConnection(t *testing.T) {
+	base := Connection{Protocol: "SASL_SSL", Username: "admin", PasswordEnv: "KAFKA_PASSWORD", TLSCA: "/etc/ca.pem"}
+	conn := mergeConnection(base, Connection{Broker: "prod:9093", PasswordCommand: "vault read prod"})
+	expected := Connection{Broker: "prod:9093", Protocol: "SASL_SSL", Username: "admin", PasswordCommand: "vault read prod", TLSCA: "/etc/ca.pem"}
+	if !reflect.DeepEqual(conn, expected) {
+		t.Errorf("mergeConnection failed:\n%+v\n%+v", conn, expected)
+	}
+}
+
+func TestSelectClusters(t *testing.T) {
+	spec := Spec{Clusters: []Cluster{{Name: "dev"}, {Name: "stage"}, {Name: "prod"}}}
+	if names, err := selectClusters(spec, "all"); err != nil || strings.Join(names, ",") != "dev,stage,prod" {
+		t.Errorf("selectClusters failed for all: %v %v", names, err)
+	}
+	if names, err := selectClusters(spec, "prod, stage"); err != nil || strings.Join(names, ",") != "prod,stage" {
+		t.Errorf("selectClusters failed: %v %v", names, err)
+	}
+	if _, err := selectClusters(spec, "qa"); err == nil {
+		t.Errorf("selectClusters must fail for unknown cluster")
+	}
+	if _, err := selectClusters(Spec{}, "all"); err == nil {
+		t.Errorf("selectClusters must fail without clusters")
+	}
+	if _, err := resolveCluster(spec, ""); err == nil {
+		t.Errorf("resolveCluster must fail if the spec has clusters but none is selected")
+	}
+}
+
+func TestApplySpecFileClusters(t *testing.T) {
+	var brokers []*sarama.MockBroker
+	for i := 0; i < 2; i++ {
+		seedBroker := sarama.NewMockBroker(t, int32(i+1))
+		defer seedBroker.Close()
+		seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetController(seedBroker.BrokerID()).
+				SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+				SetLeader("my_topic", 0, seedBroker.BrokerID()),
+			"DescribeConfigsRequest":  sarama.NewMockDescribeConfigsResponse(t),
+			"CreateTopicsRequest":     sarama.NewMockCreateTopicsResponse(t),
+			"AlterConfigsRequest":     sarama.NewMockAlterConfigsResponse(t),
+			"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+		})
+		brokers = append(brokers, seedBroker)
+	}
+
+	broker = ""
+	varFlags = arrFlags{"Prod=" + brokers[0].Addr(), "Stage=" + brokers[1].Addr()}
+	isTemplate = true
+	specFiles = arrFlags{"testdata/apply_spec_clusters.yaml"}
+	clusterSelection = "all"
+	defer func() { varFlags, isTemplate, clusterSelection = nil, false, "" }()
+
+	out, err := captureOutput(func() error { return forEachCluster(applyTestSpec) })
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+	expected := []string{
+		"CLUSTER [prod] ",
+		"[TOPIC : Modify topic my_topic (partitions=3)]",
+		"CLUSTER [stage] ",
+		"[TOPIC : Modify topic my_topic (partitions=1)]",
+		"CLUSTERS ",
+		" prod         [" + brokers[0].Addr() + "] ok=0   " + Default + Changed + " changed=2   ",
+		" stage        [" + brokers[1].Addr() + "]" + Ok + " ok=1   " + Default + Changed + " changed=1   ",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+	if broker != "" {
+		t.Errorf("The connection settings of the cluster must be restored, broker is %s", broker)
+	}
+
+	clusterSelection = "qa"
+	if _, err = captureOutput(func() error { return forEachCluster(applyTestSpec) }); err == nil {
+		t.Errorf("Apply must fail for unknown cluster")
+	}
+}
+
+func applyTestSpec(spec Spec) error {
+	return applySpec(spec, nil)
+}
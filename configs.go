@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// TopicConfigs are the per-topic config overrides. The values can be also written as numbers
+// and booleans, in JSON the same way as in YAML, e.g. "retention.ms": 86400000
+type TopicConfigs map[string]string
+
+// UnmarshalJSON converts the number and boolean values to strings, the numbers are kept as written
+func (c *TopicConfigs) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*c = nil
+		return nil
+	}
+	configs := make(TopicConfigs, len(raw))
+	for key, val := range raw {
+		switch val := val.(type) {
+		case string:
+			configs[key] = val
+		case json.Number:
+			configs[key] = val.String()
+		case bool:
+			configs[key] = strconv.FormatBool(val)
+		case nil:
+			configs[key] = ""
+		default:
+			return errors.New("Value of config " + key + " must be a string, number or boolean")
+		}
+	}
+	*c = configs
+	return nil
+}
+
+// describeTopicConfigs re-reads the configs of the topics along with their sources
+// and keeps in ConfigEntries only the per-topic overrides (or all the configs if withDefaults is set).
+// ListTopics drops the DEFAULT_CONFIG entries but keeps the ones inherited from the broker
+// config, so they would look like overrides otherwise
+func describeTopicConfigs(clusterAdmin *sarama.ClusterAdmin, topics map[string]sarama.TopicDetail, withDefaults bool) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	admin := *clusterAdmin
+	controller, err := admin.Controller()
+	if err != nil {
+		return err
+	}
+
+	// The config sources are reported since Kafka 1.1, the older brokers report only the default flag
+	request := &sarama.DescribeConfigsRequest{Version: 0}
+	if clusterVersion.IsAtLeast(sarama.V1_1_0_0) {
+		request.Version = 1
+	}
+	for name := range topics {
+		request.Resources = append(request.Resources, &sarama.ConfigResource{
+			Type: sarama.TopicResource,
+			Name: name,
+		})
+	}
+	response, err := controller.DescribeConfigs(request)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range response.Resources {
+		if resource.ErrorCode != 0 {
+			return errors.New(resource.ErrorMsg)
+		}
+		detail, found := topics[resource.Name]
+		if !found {
+			continue
+		}
+		detail.ConfigEntries = make(map[string]*string)
+		for _, entry := range resource.Configs {
+			if entry.Sensitive || !(withDefaults || isTopicOverride(entry)) {
+				continue
+			}
+			value := entry.Value
+			detail.ConfigEntries[entry.Name] = &value
+		}
+		topics[resource.Name] = detail
+	}
+	return nil
+}
+
+// isTopicOverride checks whether the config is set on the topic level.
+// If the broker does not report the source then the entry is treated as an override
+func isTopicOverride(entry *sarama.ConfigEntry) bool {
+	switch entry.Source {
+	case sarama.SourceTopic:
+		return true
+	case sarama.SourceUnknown:
+		return !entry.Default
+	}
+	return false
+}
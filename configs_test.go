@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"strings"
+	"testing"
+)
+
+func TestIsTopicOverride(t *testing.T) {
+	var tests = []struct {
+		entry sarama.ConfigEntry
+		out   bool
+	}{
+		{sarama.ConfigEntry{Source: sarama.SourceTopic}, true},
+		{sarama.ConfigEntry{Source: sarama.SourceStaticBroker}, false},
+		{sarama.ConfigEntry{Source: sarama.SourceDynamicDefaultBroker}, false},
+		{sarama.ConfigEntry{Source: sarama.SourceDefault, Default: true}, false},
+		{sarama.ConfigEntry{Source: sarama.SourceUnknown}, true},
+		{sarama.ConfigEntry{Source: sarama.SourceUnknown, Default: true}, false},
+	}
+
+	for i, tt := range tests {
+		val := isTopicOverride(&tt.entry)
+		if val != tt.out {
+			t.Errorf("isTopicOverride failed for case %d, expected %v, got %v", i, tt.out, val)
+		}
+	}
+}
+
+func TestDumpSpecDefaults(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"DescribeAclsRequest":    sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	out, err := captureOutput(func() error { return dumpSpec() })
+	if err != nil {
+		t.Fatal("Failed to dump spec: " + err.Error())
+	}
+	if strings.Contains(out, "max.message.bytes") {
+		t.Fatalf("Default config must not be dumped:\n%s", out)
+	}
+
+	dumpDefaults = true
+	defer func() { dumpDefaults = false }()
+	out, err = captureOutput(func() error { return dumpSpec() })
+	if err != nil {
+		t.Fatal("Failed to dump spec: " + err.Error())
+	}
+	if !strings.Contains(out, "max.message.bytes: \"1000000\"") {
+		t.Fatalf("Output does not contain the default config:\n%s", out)
+	}
+
+	// The brokers older than 1.1 get the request without the config sources
+	kafkaVersion = "1.0.0"
+	defer func() { kafkaVersion = "2.2.0" }()
+	if out, err = captureOutput(func() error { return dumpSpec() }); err != nil {
+		t.Fatalf("Failed to dump spec of Kafka 1.0.0: %s\n%s", err, out)
+	}
+	var request *sarama.DescribeConfigsRequest
+	for _, item := range seedBroker.History() {
+		if r, ok := item.Request.(*sarama.DescribeConfigsRequest); ok {
+			request = r
+		}
+	}
+	if request == nil || request.Version != 0 {
+		t.Errorf("DescribeConfigs request for Kafka 1.0.0 must be v0, got %+v", request)
+	}
+}
+
+func TestParseSpecJSONConfigs(t *testing.T) {
+	content := `{"topics": [{"name": "a", "configs": {"retention.ms": 86400000, "preallocate": true, "cleanup.policy": "compact"}}]}`
+	spec, err := parseSpec([]byte(content), "spec.json")
+	if err != nil {
+		t.Fatal("Failed to parse spec: " + err.Error())
+	}
+	configs := spec.Topics[0].Configs
+	if configs["retention.ms"] != "86400000" || configs["preallocate"] != "true" || configs["cleanup.policy"] != "compact" {
+		t.Errorf("JSON config values must be converted to strings: %v", configs)
+	}
+}
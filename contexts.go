@@ -0,0 +1,209 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config contains the named connection contexts, it is read from ~/.config/kafka-ops/config.yaml
+type Config struct {
+	CurrentContext string    `yaml:"current-context,omitempty"`
+	Contexts       []Context `yaml:"contexts"`
+}
+
+// Context describes the connection settings of a single cluster
+type Context struct {
+	Name       string     `yaml:"name"`
+	Connection Connection `yaml:"connection"`
+}
+
+// configPath returns the path to the config file: KAFKA_OPS_CONFIG or kafka-ops/config.yaml in the user config dir
+func configPath() string {
+	if path := loadEnvVar("KAFKA_OPS_CONFIG"); path != "" {
+		return path
+	}
+	dir := loadEnvVar("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kafka-ops", "config.yaml")
+}
+
+// readConfig reads the config file, the missing file is considered as empty config
+func readConfig(path string) (Config, error) {
+	var config Config
+	in, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = yaml.UnmarshalStrict(in, &config)
+	if err != nil {
+		return config, errors.New("Can't parse " + path + ": " + err.Error())
+	}
+	return config, nil
+}
+
+func writeConfig(path string, config Config) error {
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0600)
+}
+
+// Context returns the context by name
+func (c Config) Context(name string) (Context, bool) {
+	for _, ctx := range c.Contexts {
+		if ctx.Name == name {
+			return ctx, true
+		}
+	}
+	return Context{}, false
+}
+
+// loadContext returns the connection settings of the context defined by --context or of the current context
+func loadContext(name string) (Connection, error) {
+	path := configPath()
+	config, err := readConfig(path)
+	if err != nil {
+		return Connection{}, err
+	}
+	if name == "" {
+		name = config.CurrentContext
+		if name == "" {
+			return Connection{}, nil
+		}
+	}
+	ctx, found := config.Context(name)
+	if !found {
+		return Connection{}, errors.New("Context " + name + " is not found in " + path)
+	}
+	conn, err := resolveConnectionSecrets(ctx.Connection)
+	if err != nil {
+		return conn, errors.New("Can't read the secrets of context " + name + ": " + err.Error())
+	}
+	return conn, nil
+}
+
+// applyContext sets the connection settings from the context unless they are defined
+// by the command-line flags or by the Env variables, which take precedence
+func applyContext(conn Connection, explicit map[string]bool) {
+	for _, s := range []struct {
+		flag   string
+		env    string
+		target *string
+		value  string
+	}{
+		{"broker", "KAFKA_BROKER", &broker, conn.Broker},
+		{"protocol", "", &protocol, strings.ToLower(conn.Protocol)},
+		{"mechanism", "", &mechanism, strings.ToLower(conn.Mechanism)},
+		{"username", "KAFKA_USERNAME", &username, conn.Username},
+		{"password", "KAFKA_PASSWORD", &password, conn.Password},
+		{"tls-ca", "", &tlsCA, conn.TLSCA},
+		{"tls-cert", "", &tlsCert, conn.TLSCert},
+		{"tls-key", "", &tlsKey, conn.TLSKey},
+		{"tls-key-password", "KAFKA_TLS_KEY_PASSWORD", &tlsKeyPassword, conn.TLSKeyPassword},
+		{"tls-server-name", "", &tlsServerName, conn.TLSServerName},
+		{"kerberos-service-name", "", &kerberosServiceName, conn.KerberosServiceName},
+		{"kerberos-realm", "", &kerberosRealm, conn.KerberosRealm},
+		{"kerberos-keytab", "", &kerberosKeytab, conn.KerberosKeytab},
+		{"kerberos-ccache", "", &kerberosCcache, conn.KerberosCcache},
+		{"kerberos-config", "", &kerberosConfig, conn.KerberosConfig},
+		{"oauth-token-url", "", &oauthTokenURL, conn.OAuthTokenURL},
+		{"oauth-client-id", "", &oauthClientID, conn.OAuthClientID},
+		{"oauth-client-secret", "KAFKA_OAUTH_CLIENT_SECRET", &oauthClientSecret, conn.OAuthClientSecret},
+		{"kafka-version", "", &kafkaVersion, conn.KafkaVersion},
+	} {
+		if s.value == "" || explicit[s.flag] || (s.env != "" && loadEnvVar(s.env) != "") {
+			continue
+		}
+		*s.target = s.value
+	}
+	if conn.TLSInsecure && !explicit["tls-insecure"] {
+		tlsInsecure = true
+	}
+	if len(conn.OAuthScopes) > 0 && !explicit["oauth-scope"] {
+		oauthScopes = conn.OAuthScopes
+	}
+}
+
+// configCommand handles "kafka-ops config list|use|show"
+func configCommand(args []string) error {
+	path := configPath()
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("Please define one of the config commands: list, use <context>, show [<context>]")
+	}
+
+	switch args[0] {
+	case "list":
+		if len(config.Contexts) == 0 {
+			fmt.Println("No contexts defined in " + path)
+		}
+		for _, ctx := range config.Contexts {
+			current := " "
+			if ctx.Name == config.CurrentContext {
+				current = "*"
+			}
+			fmt.Printf("%s %-20s %s\n", current, ctx.Name, ctx.Connection.Broker)
+		}
+	case "use":
+		if len(args) != 2 {
+			return errors.New("Usage: kafka-ops config use <context>")
+		}
+		if _, found := config.Context(args[1]); !found {
+			return errors.New("Context " + args[1] + " is not found in " + path)
+		}
+		config.CurrentContext = args[1]
+		err = writeConfig(path, config)
+		if err != nil {
+			return errors.New("Can't write " + path + ": " + err.Error())
+		}
+		fmt.Println("Switched to context " + args[1])
+	case "show":
+		name := config.CurrentContext
+		if len(args) > 1 {
+			name = args[1]
+		}
+		if name == "" {
+			return errors.New("No current context, please define the context name")
+		}
+		ctx, found := config.Context(name)
+		if !found {
+			return errors.New("Context " + name + " is not found in " + path)
+		}
+		ctx.Connection = maskConnection(ctx.Connection)
+		out, _ := yaml.Marshal(ctx)
+		fmt.Print(string(out))
+	default:
+		return errors.New("Unknown config command " + args[0] + ", available: list, use, show")
+	}
+	return nil
+}
+
+// maskConnection hides the secrets of the connection settings
+func maskConnection(conn Connection) Connection {
+	for _, secret := range []*string{&conn.Password, &conn.TLSKeyPassword, &conn.OAuthClientSecret} {
+		if *secret != "" {
+			*secret = "********"
+		}
+	}
+	return conn
+}
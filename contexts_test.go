@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testConfig = `current-context: stage
+contexts:
+- name: prod
+  connection:
+    broker: prod1:9093,prod2:9093
+    protocol: SASL_SSL
+    username: admin
+    password: prod-secret
+- name: stage
+  connection:
+    broker: stage1:9092
+`
+
+func TestApplyContext(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KAFKA_OPS_CONFIG", path)
+	defer os.Unsetenv("KAFKA_OPS_CONFIG")
+	defer func() { broker, protocol, username, password = "", "plaintext", "", "" }()
+
+	conn, err := loadContext("")
+	if err != nil || conn.Broker != "stage1:9092" {
+		t.Fatalf("loadContext failed for the current context: %v %v", conn, err)
+	}
+	if _, err = loadContext("dev"); err == nil {
+		t.Fatalf("loadContext must fail for unknown context")
+	}
+
+	conn, _ = loadContext("prod")
+	broker, protocol, username, password = "", "plaintext", "", ""
+	applyContext(conn, map[string]bool{})
+	if broker != "prod1:9093,prod2:9093" || protocol != "sasl_ssl" || username != "admin" || password != "prod-secret" {
+		t.Errorf("applyContext failed: %s %s %s %s", broker, protocol, username, password)
+	}
+
+	// Command-line flags and Env variables take precedence over the context
+	broker, protocol, username, password = "flag:9092", "plaintext", "", ""
+	os.Setenv("KAFKA_USERNAME", "env-user")
+	defer os.Unsetenv("KAFKA_USERNAME")
+	applyContext(conn, map[string]bool{"broker": true, "protocol": true})
+	if broker != "flag:9092" || protocol != "plaintext" || username != "" || password != "prod-secret" {
+		t.Errorf("applyContext must not override flags and Env variables: %s %s %s %s", broker, protocol, username, password)
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KAFKA_OPS_CONFIG", path)
+	defer os.Unsetenv("KAFKA_OPS_CONFIG")
+
+	out, err := captureOutput(func() error { return configCommand([]string{"use", "prod"}) })
+	if err != nil {
+		t.Fatalf("config use failed: %s\n%s", err, out)
+	}
+
+	out, err = captureOutput(func() error { return configCommand([]string{"list"}) })
+	if err != nil || !strings.Contains(out, "* prod ") || !strings.Contains(out, "  stage ") {
+		t.Fatalf("config list failed: %v\n%s", err, out)
+	}
+
+	out, err = captureOutput(func() error { return configCommand([]string{"show"}) })
+	if err != nil || !strings.Contains(out, "broker: prod1:9093,prod2:9093") || strings.Contains(out, "prod-secret") {
+		t.Fatalf("config show failed: %v\n%s", err, out)
+	}
+
+	_, err = captureOutput(func() error { return configCommand([]string{"use", "dev"}) })
+	if err == nil {
+		t.Fatalf("config use must fail for unknown context")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Encrypted values are written in the spec as ENC[AES256_GCM,<base64 of nonce and ciphertext>]
+const encryptedPrefix = "ENC[AES256_GCM,"
+
+var encryptedValueRegex = regexp.MustCompile(`(["']?)ENC\[AES256_GCM,([A-Za-z0-9+/=]*)\](["']?)`)
+
+// loadEncryptionKey reads the 256-bit key from --encryption-key-file, KAFKA_OPS_ENCRYPTION_KEY
+// or KAFKA_OPS_ENCRYPTION_KEY_FILE. The key is base64-encoded
+func loadEncryptionKey(file string) ([]byte, error) {
+	encoded := ""
+	if file == "" {
+		encoded = loadEnvVar("KAFKA_OPS_ENCRYPTION_KEY")
+		if encoded == "" {
+			file = loadEnvVar("KAFKA_OPS_ENCRYPTION_KEY_FILE")
+		}
+	}
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("Can't read encryption key: " + err.Error())
+		}
+		encoded = string(content)
+	}
+	if encoded == "" {
+		return nil, errors.New("Encryption key not defined, please set --encryption-key-file, KAFKA_OPS_ENCRYPTION_KEY or KAFKA_OPS_ENCRYPTION_KEY_FILE")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("Encryption key must be 32 bytes encoded with base64")
+	}
+	return key, nil
+}
+
+// generateEncryptionKey returns the new random key encoded with base64
+func generateEncryptionKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue encrypts the plaintext with AES-256-GCM and returns it in ENC[...] form
+func encryptValue(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + "]", nil
+}
+
+// decryptValue decrypts the value in ENC[...] form
+func decryptValue(key []byte, value string) (string, error) {
+	match := encryptedValueRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || match[1] != "" || match[3] != "" || match[0] != strings.TrimSpace(value) {
+		return "", errors.New("Value must be in " + encryptedPrefix + "...] form")
+	}
+	return decryptData(key, match[2])
+}
+
+func decryptData(key []byte, data string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", errors.New("Encrypted value is not valid base64: " + err.Error())
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("Encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Can't decrypt the value, the encryption key is wrong or the value is corrupted")
+	}
+	return string(plaintext), nil
+}
+
+// decryptSpec replaces the ENC[...] values of the spec with the quoted plaintext.
+// The encrypted value must be the whole YAML or JSON scalar, the key is read only if the spec has encrypted values
+func decryptSpec(spec []byte) ([]byte, error) {
+	if !encryptedValueRegex.Match(spec) {
+		return spec, nil
+	}
+	key, err := loadEncryptionKey(encryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var decryptErr error
+	out := encryptedValueRegex.ReplaceAllFunc(spec, func(m []byte) []byte {
+		match := encryptedValueRegex.FindSubmatch(m)
+		if string(match[1]) != string(match[3]) {
+			decryptErr = errors.New("Encrypted value must be the whole value: " + string(m))
+			return m
+		}
+		plaintext, err := decryptData(key, string(match[2]))
+		if err != nil {
+			decryptErr = err
+			return m
+		}
+		// The JSON string is also a valid double-quoted YAML scalar
+		quoted, _ := json.Marshal(plaintext)
+		return quoted
+	})
+	if decryptErr != nil {
+		return nil, decryptErr
+	}
+	return out, nil
+}
+
+// checkEncryptedSpec checks the format of the ENC[...] values without decrypting them, so --validate
+// doesn't need the encryption key. The values are kept as the opaque quoted strings
+func checkEncryptedSpec(spec []byte) ([]byte, error) {
+	var checkErr error
+	out := encryptedValueRegex.ReplaceAllFunc(spec, func(m []byte) []byte {
+		match := encryptedValueRegex.FindSubmatch(m)
+		if string(match[1]) != string(match[3]) {
+			checkErr = errors.New("Encrypted value must be the whole value: " + string(m))
+			return m
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(match[2]))
+		if err != nil {
+			checkErr = errors.New("Encrypted value is not valid base64: " + err.Error())
+			return m
+		}
+		// The nonce and the authentication tag of AES-GCM are 12 and 16 bytes
+		if len(sealed) < 12+16 {
+			checkErr = errors.New("Encrypted value is too short: " + string(m))
+			return m
+		}
+		quoted, _ := json.Marshal(encryptedPrefix + string(match[2]) + "]")
+		return quoted
+	})
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return out, nil
+}
+
+// cryptCommand handles "kafka-ops encrypt|decrypt [<value>]", the value is read from stdin if not defined
+func cryptCommand(action string, args []string) error {
+	flags := flag.NewFlagSet(action, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	keyFile := flags.String("encryption-key-file", "", "")
+	generateKey := flags.Bool("generate-key", false, "")
+	if err := flags.Parse(args); err != nil {
+		return errors.New("Usage: kafka-ops " + action + " [--encryption-key-file <file>] [<value>]")
+	}
+
+	if *generateKey {
+		if action != "encrypt" {
+			return errors.New("--generate-key can be used only with encrypt")
+		}
+		key, err := generateEncryptionKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	key, err := loadEncryptionKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	switch flags.NArg() {
+	case 0:
+		value, err = readValue(os.Stdin)
+		if err != nil {
+			return err
+		}
+	case 1:
+		value = flags.Arg(0)
+	default:
+		return errors.New("Only one value can be defined")
+	}
+
+	var out string
+	if action == "encrypt" {
+		out, err = encryptValue(key, value)
+	} else {
+		out, err = decryptValue(key, value)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
+
+// readValue reads the whole input without the trailing newlines
+func readValue(in io.Reader) (string, error) {
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
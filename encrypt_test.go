@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncryptValue(t *testing.T) {
+	encoded, err := generateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KAFKA_OPS_ENCRYPTION_KEY", encoded)
+	defer os.Unsetenv("KAFKA_OPS_ENCRYPTION_KEY")
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		t.Fatal("Failed to load encryption key: " + err.Error())
+	}
+
+	value, err := encryptValue(key, `p@ss: "word" #1`)
+	if err != nil || !strings.HasPrefix(value, encryptedPrefix) {
+		t.Fatalf("encryptValue failed: %s %v", value, err)
+	}
+	plaintext, err := decryptValue(key, value)
+	if err != nil || plaintext != `p@ss: "word" #1` {
+		t.Errorf("decryptValue failed: %s %v", plaintext, err)
+	}
+
+	other, _ := generateEncryptionKey()
+	os.Setenv("KAFKA_OPS_ENCRYPTION_KEY", other)
+	otherKey, _ := loadEncryptionKey("")
+	if _, err = decryptValue(otherKey, value); err == nil {
+		t.Errorf("decryptValue must fail with the wrong key")
+	}
+	if _, err = decryptValue(key, "plaintext"); err == nil {
+		t.Errorf("decryptValue must fail for the value not in ENC[...] form")
+	}
+
+	os.Setenv("KAFKA_OPS_ENCRYPTION_KEY", "c2hvcnQ=")
+	if _, err = loadEncryptionKey(""); err == nil {
+		t.Errorf("loadEncryptionKey must fail for the short key")
+	}
+}
+
+func TestParseSpecFileEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	encoded, _ := generateEncryptionKey()
+	encryptionKeyFile = dir + "/key"
+	defer func() { encryptionKeyFile = "" }()
+	if err := ioutil.WriteFile(encryptionKeyFile, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := loadEncryptionKey(encryptionKeyFile)
+	connPassword, _ := encryptValue(key, "conn-secret")
+	userPassword, _ := encryptValue(key, `bob's "secret"`)
+
+	specFiles = arrFlags{dir + "/spec.yaml"}
+	defer func() { specFiles = nil }()
+	content := "connection:\n  password: " + connPassword + "\nusers:\n- name: bob\n  password: '" + userPassword + "'\n"
+	if err := ioutil.WriteFile(specFiles[0], []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := parseSpecFile()
+	if err != nil {
+		t.Fatal("Failed to parse spec file: " + err.Error())
+	}
+	if spec.Connection.Password != "conn-secret" || len(spec.Users) != 1 || spec.Users[0].Password != `bob's "secret"` {
+		t.Errorf("Encrypted values are not decrypted: %+v", spec)
+	}
+	if redacted := spec.Redact().(Spec); redacted.Users[0].Password != "" || spec.Users[0].Password == "" {
+		t.Errorf("Spec.Redact must clear the user password of the copy only")
+	}
+
+	// The key is required only if the spec has encrypted values
+	encryptionKeyFile = dir + "/missing"
+	if _, err = parseSpecFile(); err == nil {
+		t.Errorf("parseSpecFile must fail without the encryption key")
+	}
+
+	// --validate checks the format of the encrypted values only, the key is not required
+	actionValidate = true
+	defer func() { actionValidate = false }()
+	if _, err = captureOutput(validateSpecFiles); err != nil {
+		t.Errorf("validateSpecFiles must not require the encryption key: %s", err)
+	}
+	content = "users:\n- name: bob\n  password: ENC[AES256_GCM,c2hvcnQ=]\n"
+	if err := ioutil.WriteFile(specFiles[0], []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = captureOutput(validateSpecFiles); err == nil || !strings.Contains(err.Error(), "Encrypted value is too short") {
+		t.Errorf("validateSpecFiles must fail for the malformed encrypted value: %v", err)
+	}
+}
+
+func TestCryptCommand(t *testing.T) {
+	encoded, _ := generateEncryptionKey()
+	keyFile := t.TempDir() + "/key"
+	if err := ioutil.WriteFile(keyFile, []byte(encoded), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := captureOutput(func() error {
+		return cryptCommand("encrypt", []string{"--encryption-key-file", keyFile, "secret"})
+	})
+	if err != nil || !strings.HasPrefix(out, encryptedPrefix) {
+		t.Fatalf("encrypt failed: %v\n%s", err, out)
+	}
+	out, err = captureOutput(func() error {
+		return cryptCommand("decrypt", []string{"--encryption-key-file", keyFile, strings.TrimSpace(out)})
+	})
+	if err != nil || out != "secret\n" {
+		t.Fatalf("decrypt failed: %v\n%s", err, out)
+	}
+
+	if _, err = captureOutput(func() error { return cryptCommand("decrypt", []string{"--generate-key"}) }); err == nil {
+		t.Errorf("decrypt must fail with --generate-key")
+	}
+}
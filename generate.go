@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Generator declares the topics and ACLs generated for every combination of the dimension values.
+// The {dimension} placeholders in their string fields are replaced by the values
+type Generator struct {
+	Dimensions map[string][]string `yaml:"dimensions" json:"dimensions"`
+	Topics     []Topic             `yaml:"topics,omitempty" json:"topics,omitempty"`
+	Acls       []Acl               `yaml:"acls,omitempty" json:"acls,omitempty"`
+}
+
+// dimensionValue is the value of a single dimension in the combination
+type dimensionValue struct {
+	name  string
+	value string
+}
+
+// The placeholder name starts with a letter, so the regex quantifiers like {2} or {1,3} are kept as is
+var placeholderRegex = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9_]*)\}`)
+
+// expandGenerators adds the topics and ACLs of the generators to the spec. The dimensions are combined
+// in the order of their names, the values keep the order of the spec, so the result is deterministic.
+// The generated topic or ACL declared more than once (also by the topics and acls of the spec) is an error
+func expandGenerators(spec Spec) (Spec, error) {
+	if len(spec.Generate) == 0 {
+		return spec, nil
+	}
+	var errs []string
+	declaredTopics := make(map[string]string)
+	declaredAcls := make(map[string]string)
+	for _, topic := range spec.Topics {
+		declaredTopics[topicKey(topic)] = "topics"
+	}
+	for _, sacl := range expandAcls(spec.Acls) {
+		declaredAcls[aclKey(sacl)] = "acls"
+	}
+
+	for i, generator := range spec.Generate {
+		combinations, err := generator.combinations()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("generate[%d]: %s", i, err))
+			continue
+		}
+		for _, combination := range combinations {
+			source := fmt.Sprintf("generate[%d] (%s)", i, describeCombination(combination))
+			generated, err := generator.expand(combination)
+			if err != nil {
+				errs = append(errs, source+": "+err.Error())
+				continue
+			}
+			for _, topic := range generated.Topics {
+				if first, found := declaredTopics[topicKey(topic)]; found {
+					errs = append(errs, fmt.Sprintf("Topic %s generated by %s collides with the one from %s", topic.Name, source, first))
+					continue
+				}
+				declaredTopics[topicKey(topic)] = source
+				spec.Topics = append(spec.Topics, topic)
+			}
+			for _, acl := range generated.Acls {
+				collision := false
+				for _, sacl := range expandAcls([]Acl{acl}) {
+					if first, found := declaredAcls[aclKey(sacl)]; found {
+						errs = append(errs, fmt.Sprintf("ACL %s generated by %s collides with the one from %s", sacl.String(), source, first))
+						collision = true
+					}
+					declaredAcls[aclKey(sacl)] = source
+				}
+				if !collision {
+					spec.Acls = append(spec.Acls, acl)
+				}
+			}
+		}
+	}
+	spec.Generate = nil
+	if len(errs) > 0 {
+		return spec, errors.New(strings.Join(errs, "\n"))
+	}
+	return spec, nil
+}
+
+// topicKey identifies the topic declaration, the topic names are case-sensitive
+func topicKey(topic Topic) string {
+	return strings.ToLower(topic.PatternType) + ":" + topic.Name
+}
+
+// aclKey identifies the ACL declaration. Only the enum parts are case-insensitive,
+// the principal, host and resource name are kept as written
+func aclKey(sacl SingleACL) string {
+	sacl.State = ""
+	sacl.PermissionType = strings.ToLower(sacl.PermissionType)
+	sacl.Operation = strings.ToLower(sacl.Operation)
+	sacl.Resource.Type = strings.ToLower(sacl.Resource.Type)
+	sacl.Resource.PatternType = strings.ToLower(sacl.Resource.PatternType)
+	return sacl.String()
+}
+
+// combinations returns the cartesian product of the dimension values, the last dimension changes first
+func (g Generator) combinations() ([][]dimensionValue, error) {
+	if len(g.Dimensions) == 0 {
+		return nil, errors.New("No dimensions defined")
+	}
+	var names []string
+	for name, values := range g.Dimensions {
+		if !placeholderRegex.MatchString("{" + name + "}") {
+			return nil, errors.New("Wrong dimension name " + name + ", it must start with a letter, only letters, digits and _ are allowed")
+		}
+		if len(values) == 0 {
+			return nil, errors.New("Dimension " + name + " has no values")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := [][]dimensionValue{nil}
+	for _, name := range names {
+		var next [][]dimensionValue
+		for _, combination := range combinations {
+			for _, value := range g.Dimensions[name] {
+				extended := append(append([]dimensionValue{}, combination...), dimensionValue{name: name, value: value})
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// expand returns the topics and ACLs of the generator with the placeholders replaced by the values of the combination
+func (g Generator) expand(combination []dimensionValue) (Generator, error) {
+	values := make(map[string]string)
+	for _, dimension := range combination {
+		values[dimension.name] = dimension.value
+	}
+	out, err := json.Marshal(Generator{Topics: g.Topics, Acls: g.Acls})
+	if err != nil {
+		return Generator{}, err
+	}
+	var unknown []string
+	expanded := placeholderRegex.ReplaceAllStringFunc(string(out), func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, found := values[name]
+		if !found {
+			unknown = append(unknown, name)
+			return placeholder
+		}
+		// The value is escaped as it is placed into a JSON string
+		escaped, _ := json.Marshal(value)
+		return strings.Trim(string(escaped), `"`)
+	})
+	if len(unknown) > 0 {
+		return Generator{}, errors.New("Unknown dimensions " + strings.Join(unknown, ", "))
+	}
+	var generated Generator
+	err = json.Unmarshal([]byte(expanded), &generated)
+	return generated, err
+}
+
+func describeCombination(combination []dimensionValue) string {
+	var parts []string
+	for _, dimension := range combination {
+		parts = append(parts, dimension.name+"="+dimension.value)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSpecFileGenerate(t *testing.T) {
+	specFiles = arrFlags{"testdata/apply_spec_generate.yaml"}
+	isTemplate = false
+	defer func() { specFiles = nil }()
+
+	spec, err := parseSpecFile()
+	if err != nil {
+		t.Fatal("Failed to parse spec file: " + err.Error())
+	}
+	var names []string
+	for _, topic := range spec.Topics {
+		names = append(names, topic.Name)
+	}
+	// The dimensions are combined in the order of their names: env, then plant
+	expected := "product.audit,product.berlin.dev.orders,product.munich.dev.orders,product.berlin.prod.orders,product.munich.prod.orders"
+	if strings.Join(names, ",") != expected || spec.Topics[1].Partitions != 3 || spec.Topics[1].Configs["retention.ms"] != "86400000" {
+		t.Errorf("Wrong generated topics: %+v", spec.Topics)
+	}
+	if len(spec.Acls) != 4 || spec.Acls[0].Principal != "User:orders-dev" || spec.Acls[3].Permissions[0].Resource.Pattern != "product.munich.prod." {
+		t.Errorf("Wrong generated ACLs: %+v", spec.Acls)
+	}
+	if spec.Generate != nil {
+		t.Errorf("The generators must be expanded")
+	}
+}
+
+func TestExpandGenerators(t *testing.T) {
+	var tests = []struct {
+		generator Generator
+		err       string
+	}{
+		{Generator{Dimensions: map[string][]string{"env": {"dev", "prod"}, "plant": {"a"}},
+			Topics: []Topic{{Name: "product.{env}.orders"}, {Name: "product.{plant}.audit"}}},
+			"Topic product.a.audit generated by generate[0] (env=prod, plant=a) collides with the one from generate[0] (env=dev, plant=a)"},
+		{Generator{Dimensions: map[string][]string{"env": {"dev"}}, Topics: []Topic{{Name: "product.audit"}}},
+			"Topic product.audit generated by generate[0] (env=dev) collides with the one from topics"},
+		{Generator{Dimensions: map[string][]string{"env": {"dev"}}, Topics: []Topic{{Name: "product.{plant}.orders"}}},
+			"generate[0] (env=dev): Unknown dimensions plant"},
+		{Generator{Dimensions: map[string][]string{"env": {}}, Topics: []Topic{{Name: "product.{env}"}}},
+			"generate[0]: Dimension env has no values"},
+		{Generator{Dimensions: map[string][]string{"2": {"a"}}, Topics: []Topic{{Name: "product.{2}"}}},
+			"generate[0]: Wrong dimension name 2, it must start with a letter"},
+		{Generator{Dimensions: map[string][]string{"env": {"dev", "prod"}},
+			Acls: []Acl{{Principal: "User:app", Permissions: []Permission{{Resource: Resource{Type: "topic", Pattern: "orders", PatternType: "LITERAL"}, Allow: []string{"READ"}}}}}},
+			"ACL ALLOW User:app@* to READ topic:LITERAL:orders generated by generate[0] (env=prod) collides with the one from generate[0] (env=dev)"},
+		{Generator{Dimensions: map[string][]string{"env": {`a"b`}}, Topics: []Topic{{Name: "product.{env}", Configs: TopicConfigs{"x": "{env}", "y": "[0-9]{2}"}}}},
+			""},
+	}
+	for _, tt := range tests {
+		spec := Spec{Topics: []Topic{{Name: "product.audit"}}, Generate: []Generator{tt.generator}}
+		spec, err := expandGenerators(spec)
+		if tt.err == "" && (err != nil || spec.Topics[1].Name != `product.a"b` || spec.Topics[1].Configs["x"] != `a"b` || spec.Topics[1].Configs["y"] != "[0-9]{2}") {
+			t.Errorf("expandGenerators failed for %+v: %v %+v", tt.generator, err, spec.Topics)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("expandGenerators must fail for %+v with %q, got %v", tt.generator, tt.err, err)
+		}
+	}
+}
+
+func TestAclKey(t *testing.T) {
+	acl := SingleACL{PermissionType: "ALLOW", Principal: "User:alice", Operation: "READ", Host: "*",
+		Resource: Resource{Type: "topic", Pattern: "Orders", PatternType: "LITERAL"}}
+	same := SingleACL{PermissionType: "allow", Principal: "User:alice", Operation: "read", Host: "*",
+		Resource: Resource{Type: "TOPIC", Pattern: "Orders", PatternType: "literal"}, State: "absent"}
+	if aclKey(acl) != aclKey(same) {
+		t.Errorf("The enum parts of the ACL key must be case-insensitive: %s != %s", aclKey(acl), aclKey(same))
+	}
+	principal, pattern := acl, acl
+	principal.Principal = "User:Alice"
+	pattern.Resource.Pattern = "orders"
+	if aclKey(acl) == aclKey(principal) || aclKey(acl) == aclKey(pattern) {
+		t.Errorf("The principal and the resource name of the ACL key must be case-sensitive")
+	}
+	if topicKey(Topic{Name: "Orders"}) == topicKey(Topic{Name: "orders"}) {
+		t.Errorf("The topic key must be case-sensitive")
+	}
+}
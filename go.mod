@@ -7,6 +7,7 @@ require (
 	github.com/IBM/sarama v1.45.1
 	github.com/xdg/scram v1.0.5
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -3,50 +3,96 @@ package main
 import (
 	"github.com/IBM/sarama"
 
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"os"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
-	"text/template"
+	"time"
 )
 
 const version string = "1.0.5"
 
 var (
-	broker        string
-	specfile      string
-	protocol      string
-	mechanism     string
-	username      string
-	password      string
-	verbose       bool
-	isYAML        bool
-	isJSON        bool
-	actionApply   bool
-	actionDump    bool
-	actionHelp    bool
-	actionVersion bool
-	errorStop     bool
-	isTemplate    bool
-	missingOk     bool
-	varFlags      arrFlags
+	broker              string
+	specFiles           arrFlags
+	overlayFiles        arrFlags
+	protocol            string
+	mechanism           string
+	username            string
+	password            string
+	verbose             bool
+	isYAML              bool
+	isJSON              bool
+	actionApply         bool
+	actionDump          bool
+	actionPlan          bool
+	actionCheck         bool
+	actionRender        bool
+	actionValidate      bool
+	actionSchema        bool
+	planFile            string
+	prune               bool
+	prunePrefix         string
+	pruneMatch          string
+	prunePrincipals     arrFlags
+	pruneIgnore         arrFlags
+	actionHelp          bool
+	actionVersion       bool
+	errorStop           bool
+	isTemplate          bool
+	missingOk           bool
+	varFlags            arrFlags
+	valuesFiles         arrFlags
+	reassignTimeout     time.Duration
+	dialTimeout         time.Duration
+	readTimeout         time.Duration
+	adminTimeout        time.Duration
+	retries             int
+	retryBackoff        time.Duration
+	retryBackoffMax     time.Duration
+	kafkaVersion        string
+	legacyAlterConfigs  bool
+	dumpDefaults        bool
+	tlsCA               string
+	tlsCert             string
+	tlsKey              string
+	tlsServerName       string
+	tlsInsecure         bool
+	tlsKeyPassword      string
+	kerberosServiceName string
+	kerberosRealm       string
+	kerberosKeytab      string
+	kerberosCcache      string
+	kerberosConfig      string
+	oauthTokenURL       string
+	oauthClientID       string
+	oauthClientSecret   string
+	oauthScopes         arrFlags
+	contextName         string
+	clusterSelection    string
+	encryptionKeyFile   string
 )
 
 type arrFlags []string
 
 // Spec contains the full structure of the manifest
 type Spec struct {
-	Topics         []Topic         `yaml:"topics" json:"topics"`
-	Acls           []Acl           `yaml:"acls" json:"acls"`
-	ConsumerGroups []ConsumerGroup `yaml:"consumer-groups,omitempty" json:"consumer-groups,omitempty"`
-	Connection     Connection      `yaml:"connection,omitempty" json:"connection,omitempty"`
+	Topics         []Topic                 `yaml:"topics" json:"topics"`
+	Acls           []Acl                   `yaml:"acls" json:"acls"`
+	ConsumerGroups []ConsumerGroup         `yaml:"consumer-groups,omitempty" json:"consumer-groups,omitempty"`
+	Quotas         []Quota                 `yaml:"quotas,omitempty" json:"quotas,omitempty"`
+	Users          []User                  `yaml:"users,omitempty" json:"users,omitempty"`
+	Connection     Connection              `yaml:"connection,omitempty" json:"connection,omitempty"`
+	Clusters       []Cluster               `yaml:"clusters,omitempty" json:"clusters,omitempty"`
+	Defaults       *TopicProfile           `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	Profiles       map[string]TopicProfile `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+	Generate       []Generator             `yaml:"generate,omitempty" json:"generate,omitempty"`
 }
 
 // Topic describes single topic
@@ -54,13 +100,16 @@ type Topic struct {
 	Name              string            `yaml:"name" json:"name"`
 	Partitions        int               `yaml:"partitions" json:"partitions"`
 	ReplicationFactor int               `yaml:"replication_factor" json:"replication_factor"`
-	Configs           map[string]string `yaml:"configs" json:"configs"`
+	Configs           TopicConfigs      `yaml:"configs" json:"configs"`
 	State             string            `yaml:"state,omitempty" json:"state,omitempty"`
 	PatternType       string            `yaml:"patternType,omitempty" json:"patternType,omitempty"`
 	Matched           []string          `yaml:"matched,omitempty" json:"matched,omitempty"`
+	ReplicaAssignment map[int32][]int32 `yaml:"replica_assignment,omitempty,flow" json:"replica_assignment,omitempty"`
+	RackAware         bool              `yaml:"rack_aware,omitempty" json:"rack_aware,omitempty"`
+	Profile           string            `yaml:"profile,omitempty" json:"profile,omitempty"`
 }
 
-// ConsumerGroup describes a consumer group to be deleted
+// ConsumerGroup describes a consumer group to be deleted (or to be kept when pruning)
 type ConsumerGroup struct {
 	Name        string   `yaml:"name" json:"name"`
 	State       string   `yaml:"state,omitempty" json:"state,omitempty"`
@@ -101,11 +150,36 @@ type SingleACL struct {
 
 // Connection describes the brokers settings defined in the manifest
 type Connection struct {
-	Broker    string `yaml:"broker,omitempty" json:"broker,omitempty"`
-	Protocol  string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
-	Mechanism string `yaml:"mechanism,omitempty" json:"mechanism,omitempty"`
-	Username  string `yaml:"username,omitempty" json:"username,omitempty"`
-	Password  string `yaml:"password,omitempty" json:"password,omitempty"`
+	Broker                   string   `yaml:"broker,omitempty" json:"broker,omitempty"`
+	Protocol                 string   `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Mechanism                string   `yaml:"mechanism,omitempty" json:"mechanism,omitempty"`
+	Username                 string   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password                 string   `yaml:"password,omitempty" json:"password,omitempty"`
+	PasswordFile             string   `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+	PasswordEnv              string   `yaml:"password_env,omitempty" json:"password_env,omitempty"`
+	PasswordCommand          string   `yaml:"password_command,omitempty" json:"password_command,omitempty"`
+	TLSCA                    string   `yaml:"tls_ca,omitempty" json:"tls_ca,omitempty"`
+	TLSCert                  string   `yaml:"tls_cert,omitempty" json:"tls_cert,omitempty"`
+	TLSKey                   string   `yaml:"tls_key,omitempty" json:"tls_key,omitempty"`
+	TLSKeyPassword           string   `yaml:"tls_key_password,omitempty" json:"tls_key_password,omitempty"`
+	TLSKeyPasswordFile       string   `yaml:"tls_key_password_file,omitempty" json:"tls_key_password_file,omitempty"`
+	TLSKeyPasswordEnv        string   `yaml:"tls_key_password_env,omitempty" json:"tls_key_password_env,omitempty"`
+	TLSKeyPasswordCommand    string   `yaml:"tls_key_password_command,omitempty" json:"tls_key_password_command,omitempty"`
+	TLSServerName            string   `yaml:"tls_server_name,omitempty" json:"tls_server_name,omitempty"`
+	TLSInsecure              bool     `yaml:"tls_insecure,omitempty" json:"tls_insecure,omitempty"`
+	KerberosServiceName      string   `yaml:"kerberos_service_name,omitempty" json:"kerberos_service_name,omitempty"`
+	KerberosRealm            string   `yaml:"kerberos_realm,omitempty" json:"kerberos_realm,omitempty"`
+	KerberosKeytab           string   `yaml:"kerberos_keytab,omitempty" json:"kerberos_keytab,omitempty"`
+	KerberosCcache           string   `yaml:"kerberos_ccache,omitempty" json:"kerberos_ccache,omitempty"`
+	KerberosConfig           string   `yaml:"kerberos_config,omitempty" json:"kerberos_config,omitempty"`
+	OAuthTokenURL            string   `yaml:"oauth_token_url,omitempty" json:"oauth_token_url,omitempty"`
+	OAuthClientID            string   `yaml:"oauth_client_id,omitempty" json:"oauth_client_id,omitempty"`
+	OAuthClientSecret        string   `yaml:"oauth_client_secret,omitempty" json:"oauth_client_secret,omitempty"`
+	OAuthClientSecretFile    string   `yaml:"oauth_client_secret_file,omitempty" json:"oauth_client_secret_file,omitempty"`
+	OAuthClientSecretEnv     string   `yaml:"oauth_client_secret_env,omitempty" json:"oauth_client_secret_env,omitempty"`
+	OAuthClientSecretCommand string   `yaml:"oauth_client_secret_command,omitempty" json:"oauth_client_secret_command,omitempty"`
+	OAuthScopes              []string `yaml:"oauth_scopes,omitempty" json:"oauth_scopes,omitempty"`
+	KafkaVersion             string   `yaml:"kafka_version,omitempty" json:"kafka_version,omitempty"`
 }
 
 // Exit is used for handling panics
@@ -122,16 +196,75 @@ const (
 func main() {
 	defer handleExit()
 	//defer fmt.Println("closed")
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		err := configCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err.Error())
+			panic(Exit{1})
+		}
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "encrypt" || os.Args[1] == "decrypt") {
+		err := cryptCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Println(err.Error())
+			panic(Exit{1})
+		}
+		return
+	}
 	validateFlags()
 
 	if actionApply {
-		err := applySpecFile()
+		var err error
+		if planFile != "" {
+			err = applyPlanFile()
+		} else {
+			err = forEachCluster(func(spec Spec) error { return applySpec(spec, nil) })
+		}
+		if err != nil {
+			if err.Error() != "" {
+				fmt.Println(err.Error())
+			}
+			panic(Exit{2})
+		}
+	} else if actionPlan {
+		err := forEachCluster(planSpec)
+		if err != nil {
+			if err.Error() != "" {
+				fmt.Println(err.Error())
+			}
+			panic(Exit{2})
+		}
+	} else if actionCheck {
+		drift := false
+		err := forEachCluster(func(spec Spec) error {
+			clusterDrift, err := checkSpec(spec)
+			drift = drift || clusterDrift
+			return err
+		})
 		if err != nil {
 			if err.Error() != "" {
 				fmt.Println(err.Error())
 			}
 			panic(Exit{2})
 		}
+		if drift {
+			panic(Exit{3})
+		}
+	} else if actionRender {
+		err := renderSpec()
+		if err != nil {
+			fmt.Println(err.Error())
+			panic(Exit{2})
+		}
+	} else if actionValidate {
+		err := validateSpecFiles()
+		if err != nil {
+			fmt.Println(err.Error())
+			panic(Exit{2})
+		}
+	} else if actionSchema {
+		printSchema()
 	} else if actionDump {
 		err := dumpSpec()
 		if err != nil {
@@ -150,29 +283,49 @@ func main() {
 func connectToKafkaCluster() (*sarama.ClusterAdmin, error) {
 	brokerAddrs := strings.Split(broker, ",")
 	config := sarama.NewConfig()
-	config.Version = sarama.V2_2_0_0
+	if dialTimeout > 0 {
+		config.Net.DialTimeout = dialTimeout
+	}
+	if readTimeout > 0 {
+		config.Net.ReadTimeout = readTimeout
+		config.Net.WriteTimeout = readTimeout
+	}
+	if adminTimeout > 0 {
+		config.Admin.Timeout = adminTimeout
+	}
+	if kafkaVersion != "" && kafkaVersion != "auto" {
+		v, err := sarama.ParseKafkaVersion(kafkaVersion)
+		if err != nil {
+			return nil, errors.New("Wrong Kafka version: " + err.Error())
+		}
+		config.Version = v
+	}
 
 	if strings.HasPrefix(protocol, "sasl_") {
-		config.Net.SASL.Enable = true
-		config.Net.SASL.User = username
-		config.Net.SASL.Password = password
-		if mechanism == "scram-sha-256" {
-			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
-			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA256} }
-		} else if mechanism == "scram-sha-512" {
-			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
-			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA512} }
-		} else {
-			return nil, errors.New("The only supported SASL mechanisms: scram-sha-256, scram-sha-512")
+		err := configureSASL(config)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if strings.HasSuffix(protocol, "_ssl") {
+	if protocol == "ssl" || protocol == "sasl_ssl" {
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
 		config.Net.TLS.Enable = true
-		tlsConfig := tls.Config{
-			InsecureSkipVerify: true,
+		config.Net.TLS.Config = tlsConfig
+	} else if protocol != "plaintext" && protocol != "sasl_plaintext" {
+		return nil, errors.New("The only supported protocols: plaintext, ssl, sasl_plaintext, sasl_ssl")
+	}
+
+	if kafkaVersion == "" || kafkaVersion == "auto" {
+		v, err := detectKafkaVersion(brokerAddrs, config)
+		if err != nil {
+			return nil, err
 		}
-		config.Net.TLS.Config = &tlsConfig
+		config.Version = v
 	}
+	clusterVersion = config.Version
 
 	admin, err := sarama.NewClusterAdmin(brokerAddrs, config)
 	if err != nil {
@@ -201,6 +354,10 @@ func dumpSpec() error {
 	if err != nil {
 		return err
 	}
+	err = describeTopicConfigs(admin, currentTopics, dumpDefaults)
+	if err != nil {
+		return errors.New("Can't describe topic configs: " + err.Error())
+	}
 
 	// Get current ACLs from broker
 	currentAcls, err := listAllAcls(admin)
@@ -214,15 +371,7 @@ func dumpSpec() error {
 		if strings.HasPrefix(name, "__") {
 			continue
 		}
-		var topic Topic
-		topic.Name = name
-		topic.Partitions = int(currentTopic.NumPartitions)
-		topic.ReplicationFactor = int(currentTopic.ReplicationFactor)
-		topic.Configs = make(map[string]string)
-		for key, val := range currentTopic.ConfigEntries {
-			topic.Configs[key] = *val
-		}
-		spec.Topics = append(spec.Topics, topic)
+		spec.Topics = append(spec.Topics, topicFromDetail(name, currentTopic))
 	}
 
 	for _, resourceAcls := range currentAcls {
@@ -250,6 +399,15 @@ func dumpSpec() error {
 		}
 	}
 
+	// Get current quotas from broker, they are skipped if the Kafka version does not support them
+	// or the principal is not allowed to describe them
+	spec.Quotas, err = listAllQuotas(admin)
+	if errors.Is(err, sarama.ErrClusterAuthorizationFailed) {
+		fmt.Fprintf(os.Stderr, "Warning: quotas are not dumped: %s\n", err)
+	} else if err != nil && !errors.Is(err, sarama.ErrUnsupportedVersion) {
+		return errors.New("Can't list quotas: " + err.Error())
+	}
+
 	if isJSON {
 		jsonTopic, _ := json.MarshalIndent(spec, "", "    ")
 		fmt.Printf(string(jsonTopic))
@@ -283,6 +441,19 @@ func (r Resource) Equals(res Resource) bool {
 	return r.Type == res.Type && r.Pattern == res.Pattern && r.PatternType == res.PatternType
 }
 
+// patternMatches checks whether the name matches the pattern of the given type (literal, prefixed or match)
+func patternMatches(name string, pattern string, patternType string) bool {
+	switch strings.ToLower(patternType) {
+	case "prefixed":
+		return strings.HasPrefix(name, pattern)
+	case "match":
+		matched, _ := regexp.MatchString(pattern, name)
+		return matched
+	default:
+		return name == pattern
+	}
+}
+
 func getHost(s string) string {
 	split := strings.Split(string(s), ":")
 	if len(split) > 1 {
@@ -310,32 +481,110 @@ func listAllAcls(admin *sarama.ClusterAdmin) ([]sarama.ResourceAcls, error) {
 	return currentAcls, nil
 }
 
+// applySpecFile parses the spec and applies it to the cluster
 func applySpecFile() error {
-	var numOk, numChanged, numError int
-
 	spec, err := parseSpecFile()
 	if err != nil {
 		return errors.New("Can't parse spec manifest: " + err.Error())
 	}
+	return applySpec(spec, nil)
+}
+
+// applySpec applies the spec to the cluster, in the dry-run mode the changes are only recorded to the plan.
+// The saved plan is applied with its spec, its broker and the cluster fingerprint are checked first
+func applySpec(spec Spec, savedPlan *Plan) error {
+	var numOk, numChanged, numError int
+	plan = Plan{}
+
+	var err error
+	if savedPlan != nil {
+		spec = savedPlan.Spec
+		prune = savedPlan.Prune != nil
+		if prune {
+			prunePrefix = savedPlan.Prune.Prefix
+			pruneMatch = savedPlan.Prune.Match
+			prunePrincipals = savedPlan.Prune.Principals
+			pruneIgnore = savedPlan.Prune.Ignore
+		}
+	}
+	spec, err = resolveCluster(spec, clusterName)
+	if err != nil {
+		return err
+	}
 
-	if spec.Connection.Broker != "" {
-		broker = spec.Connection.Broker
+	connection, err := resolveConnectionSecrets(spec.Connection)
+	if err != nil {
+		return errors.New("Can't read connection secrets: " + err.Error())
 	}
-	if spec.Connection.Protocol != "" {
-		protocol = strings.ToLower(spec.Connection.Protocol)
+	if connection.Broker != "" {
+		broker = connection.Broker
 	}
-	if spec.Connection.Mechanism != "" {
-		mechanism = strings.ToLower(spec.Connection.Mechanism)
+	if connection.Protocol != "" {
+		protocol = strings.ToLower(connection.Protocol)
 	}
-	if spec.Connection.Username != "" {
-		username = spec.Connection.Username
+	if connection.Mechanism != "" {
+		mechanism = strings.ToLower(connection.Mechanism)
 	}
-	if spec.Connection.Password != "" {
-		password = spec.Connection.Password
+	if connection.Username != "" {
+		username = connection.Username
+	}
+	if connection.Password != "" {
+		password = connection.Password
+	}
+	if connection.TLSCA != "" {
+		tlsCA = connection.TLSCA
+	}
+	if connection.TLSCert != "" {
+		tlsCert = connection.TLSCert
+	}
+	if connection.TLSKey != "" {
+		tlsKey = connection.TLSKey
+	}
+	if connection.TLSKeyPassword != "" {
+		tlsKeyPassword = connection.TLSKeyPassword
+	}
+	if connection.TLSServerName != "" {
+		tlsServerName = connection.TLSServerName
+	}
+	if connection.TLSInsecure {
+		tlsInsecure = true
+	}
+	if connection.KerberosServiceName != "" {
+		kerberosServiceName = connection.KerberosServiceName
+	}
+	if connection.KerberosRealm != "" {
+		kerberosRealm = connection.KerberosRealm
+	}
+	if connection.KerberosKeytab != "" {
+		kerberosKeytab = connection.KerberosKeytab
+	}
+	if connection.KerberosCcache != "" {
+		kerberosCcache = connection.KerberosCcache
+	}
+	if connection.KerberosConfig != "" {
+		kerberosConfig = connection.KerberosConfig
+	}
+	if connection.OAuthTokenURL != "" {
+		oauthTokenURL = connection.OAuthTokenURL
+	}
+	if connection.OAuthClientID != "" {
+		oauthClientID = connection.OAuthClientID
+	}
+	if connection.OAuthClientSecret != "" {
+		oauthClientSecret = connection.OAuthClientSecret
+	}
+	if len(connection.OAuthScopes) > 0 {
+		oauthScopes = connection.OAuthScopes
+	}
+	if connection.KafkaVersion != "" {
+		kafkaVersion = connection.KafkaVersion
 	}
 	if broker == "" {
 		broker = "localhost:9092"
 	}
+	if savedPlan != nil && savedPlan.Broker != broker {
+		return errors.New("The plan was made for broker " + savedPlan.Broker + ", not for " + broker + ". Please re-run --plan")
+	}
 
 	admin, err := connectToKafkaCluster()
 	if err != nil {
@@ -343,6 +592,11 @@ func applySpecFile() error {
 	}
 	defer func() { _ = (*admin).Close() }()
 
+	err = checkSpecFeatures(spec)
+	if err != nil {
+		return err
+	}
+
 	// Get number of brokers
 	brokers, _, err := (*admin).DescribeCluster()
 	if err != nil {
@@ -360,6 +614,57 @@ func applySpecFile() error {
 	if err != nil {
 		return errors.New("Can't list topics: " + err.Error())
 	}
+	err = describeTopicConfigs(admin, currentTopics, false)
+	if err != nil {
+		return errors.New("Can't describe topic configs: " + err.Error())
+	}
+
+	// Get current consumer-groups from broker
+	var currentGroups map[string]string
+	if len(spec.ConsumerGroups) > 0 || prune {
+		currentGroups, err = (*admin).ListConsumerGroups()
+		if err != nil {
+			return errors.New("Can't list consumer-groups: " + err.Error())
+		}
+	}
+
+	// Get current ACLs from broker
+	var currentAcls []sarama.ResourceAcls
+	if len(spec.Acls) > 0 || prune {
+		currentAcls, err = listAllAcls(admin)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Get current quotas from broker
+	var currentQuotas []Quota
+	if len(spec.Quotas) > 0 {
+		currentQuotas, err = listAllQuotas(admin)
+		if err != nil {
+			return errors.New("Can't list quotas: " + quotaError(err).Error())
+		}
+	}
+
+	// Get current SCRAM users from broker
+	var currentUsers []User
+	if len(spec.Users) > 0 {
+		currentUsers, err = listScramUsers(admin)
+		if err != nil {
+			return errors.New("Can't list users: " + err.Error())
+		}
+	}
+
+	plan.Version = version
+	plan.Broker = broker
+	plan.Fingerprint = clusterFingerprint(currentTopics, currentGroups, currentAcls, currentQuotas, currentUsers)
+	plan.Spec = spec.Redact().(Spec)
+	if prune {
+		plan.Prune = &PruneOptions{Prefix: prunePrefix, Match: pruneMatch, Principals: prunePrincipals, Ignore: pruneIgnore}
+	}
+	if savedPlan != nil && savedPlan.Fingerprint != plan.Fingerprint {
+		return errors.New("The cluster has changed since the plan was made. Please re-run --plan")
+	}
 
 	// Iterate over topics
 	for _, topic := range spec.Topics {
@@ -372,14 +677,10 @@ func applySpecFile() error {
 				var currentState = Ok
 				var currentError = ""
 				for currentTopicName, _ := range currentTopics {
-					var matched = false
-					if topic.PatternType == "prefixed" {
-						matched = strings.HasPrefix(currentTopicName, topic.Name)
-					} else if topic.PatternType == "match" {
-						matched, _ = regexp.MatchString(topic.Name, currentTopicName)
-					}
-					if matched {
+					if patternMatches(currentTopicName, topic.Name, topic.PatternType) {
 						topic.Matched = append(topic.Matched, currentTopicName)
+						plan.Add(PlanOperation{Kind: "topic", Name: currentTopicName, Action: ActionDelete,
+							Current: topicFromDetail(currentTopicName, currentTopics[currentTopicName])})
 						err := deleteTopic(currentTopicName, admin)
 						if err != nil {
 							numError++
@@ -417,11 +718,19 @@ func applySpecFile() error {
 				printResult(Ok, broker, "", topic)
 				numOk++
 			} else {
+				var assignment [][]int32
+				topic, err = normalizeReplicaAssignment(topic, brokers)
 				if topic.ReplicationFactor < 1 {
 					topic.ReplicationFactor = autoReplicationFactor
 				}
 				fmt.Printf("TASK [TOPIC : Create topic %s (partitions=%d, replicas=%d)] %s\n", topic.Name, topic.Partitions, topic.ReplicationFactor, strings.Repeat("*", 25))
-				err := createTopic(topic, admin)
+				if err == nil {
+					assignment, err = topicAssignment(topic, 0, topic.Partitions, brokers)
+				}
+				if err == nil {
+					plan.Add(PlanOperation{Kind: "topic", Name: topic.Name, Action: ActionCreate, Desired: topic})
+					err = createTopic(topic, admin, assignment)
+				}
 				if err != nil {
 					printResult(Error, broker, err.Error(), topic)
 					numError++
@@ -437,6 +746,8 @@ func applySpecFile() error {
 		} else {
 			// Topic exists
 			if topic.State == "absent" {
+				plan.Add(PlanOperation{Kind: "topic", Name: topic.Name, Action: ActionDelete,
+					Current: topicFromDetail(topic.Name, currentTopic)})
 				err := deleteTopic(topic.Name, admin)
 				if err != nil {
 					printResult(Error, broker, err.Error(), topic)
@@ -452,11 +763,50 @@ func applySpecFile() error {
 			} else {
 				var topicAltered bool = false
 				var topicConfigAlterNeeded = false
-				// Check the replication-factor
+				topic, err = normalizeReplicaAssignment(topic, brokers)
 				if topic.ReplicationFactor > 0 {
 					fmt.Printf("TASK [TOPIC : Modify topic %s (partitions=%d, replicas=%d)] %s\n", topic.Name, topic.Partitions, topic.ReplicationFactor, strings.Repeat("*", 25))
-					if int16(topic.ReplicationFactor) != currentTopic.ReplicationFactor {
-						printResult(Error, broker, "Cannot change replication-factor. Consider doing it manually with kafka-reassign-partitions utility or re-creating the topic", topic)
+				} else {
+					fmt.Printf("TASK [TOPIC : Modify topic %s (partitions=%d)] %s\n", topic.Name, topic.Partitions, strings.Repeat("*", 37))
+				}
+				if err != nil {
+					printResult(Error, broker, err.Error(), topic)
+					numError++
+					if errorStop {
+						break
+					} else {
+						continue
+					}
+				}
+				// Check the replica assignment and the replication-factor
+				if len(topic.ReplicaAssignment) > 0 {
+					if assignmentDiffers(currentTopic.ReplicaAssignment, topic.ReplicaAssignment) {
+						err := requireKafkaVersion("changing the replica assignment", sarama.V2_4_0_0)
+						if err == nil {
+							plan.Add(PlanOperation{Kind: "replica-assignment", Name: topic.Name, Action: ActionAlter,
+								Current: currentTopic.ReplicaAssignment, Desired: topic.ReplicaAssignment})
+							err = alterReplicaAssignment(topic, admin, int(currentTopic.NumPartitions))
+						}
+						if err != nil {
+							printResult(Error, broker, err.Error(), topic)
+							numError++
+							if errorStop {
+								break
+							} else {
+								continue
+							}
+						}
+						topicAltered = true
+					}
+				} else if topic.ReplicationFactor > 0 && int16(topic.ReplicationFactor) != currentTopic.ReplicationFactor {
+					err := requireKafkaVersion("changing the replication-factor", sarama.V2_4_0_0)
+					if err == nil {
+						plan.Add(PlanOperation{Kind: "replication-factor", Name: topic.Name, Action: ActionAlter,
+							Current: int(currentTopic.ReplicationFactor), Desired: topic.ReplicationFactor})
+						err = alterReplicationFactor(topic, admin, currentTopic.ReplicaAssignment, brokers)
+					}
+					if err != nil {
+						printResult(Error, broker, err.Error(), topic)
 						numError++
 						if errorStop {
 							break
@@ -464,12 +814,22 @@ func applySpecFile() error {
 							continue
 						}
 					}
-				} else {
-					fmt.Printf("TASK [TOPIC : Modify topic %s (partitions=%d)] %s\n", topic.Name, topic.Partitions, strings.Repeat("*", 37))
+					topicAltered = true
 				}
 				// Check the partitions count
 				if int32(topic.Partitions) != currentTopic.NumPartitions {
-					err := alterNumPartitions(topic.Name, admin, topic.Partitions)
+					plan.Add(PlanOperation{Kind: "partitions", Name: topic.Name, Action: ActionAlter,
+						Current: int(currentTopic.NumPartitions), Desired: topic.Partitions})
+					var assignment [][]int32
+					if int32(topic.Partitions) > currentTopic.NumPartitions {
+						if topic.ReplicationFactor < 1 {
+							topic.ReplicationFactor = int(currentTopic.ReplicationFactor)
+						}
+						assignment, err = topicAssignment(topic, int(currentTopic.NumPartitions), topic.Partitions-int(currentTopic.NumPartitions), brokers)
+					}
+					if err == nil {
+						err = alterNumPartitions(topic.Name, admin, topic.Partitions, assignment)
+					}
 					if err != nil {
 						printResult(Error, broker, err.Error(), topic)
 						numError++
@@ -482,16 +842,22 @@ func applySpecFile() error {
 					topicAltered = true
 				}
 				// Check the configs
-				for key, val := range topic.Configs {
+				for _, key := range sortedKeys(topic.Configs) {
+					val := topic.Configs[key]
 					currentVal, found := currentTopic.ConfigEntries[key]
 					if found {
 						if val != *currentVal {
 							topicConfigAlterNeeded = true
-							break
+							op := PlanOperation{Kind: "config", Name: topic.Name, Key: key, Action: ActionAlter, Current: *currentVal, Desired: val}
+							if val == "default" {
+								op.Action = ActionDelete
+								op.Desired = nil
+							}
+							plan.Add(op)
 						}
 					} else if val != "default" {
 						topicConfigAlterNeeded = true
-						break
+						plan.Add(PlanOperation{Kind: "config", Name: topic.Name, Key: key, Action: ActionCreate, Desired: val})
 					}
 				}
 				if topicConfigAlterNeeded {
@@ -519,17 +885,36 @@ func applySpecFile() error {
 		}
 	}
 
-	if len(spec.ConsumerGroups) > 0 {
-		// Get current consumer-groups from broker
-		currentGroups, err := (*admin).ListConsumerGroups()
-		if err != nil {
-			return errors.New("Can't list consumer-groups: " + err.Error())
+	if prune {
+		// Delete topics which are not defined in the spec
+		for _, name := range topicsToPrune(spec.Topics, currentTopics) {
+			fmt.Printf("TASK [TOPIC : Prune topic %s] %s\n", name, strings.Repeat("*", 53))
+			topic := topicFromDetail(name, currentTopics[name])
+			topic.State = "absent"
+			plan.Add(PlanOperation{Kind: "topic", Name: name, Action: ActionDelete, Current: topicFromDetail(name, currentTopics[name])})
+			err := deleteTopic(name, admin)
+			if err != nil {
+				printResult(Error, broker, err.Error(), topic)
+				numError++
+				if errorStop {
+					break
+				}
+				continue
+			}
+			printResult(Changed, broker, "", topic)
+			numChanged++
 		}
+	}
 
+	if len(spec.ConsumerGroups) > 0 {
 		// Iterate over consumer-groups
 		for _, group := range spec.ConsumerGroups {
+			if group.State == "present" {
+				// Such groups are only declared for keeping them in prune mode
+				continue
+			}
 			if group.State != "absent" {
-				return errors.New("Consumer-groups support only state=absent")
+				return errors.New("Consumer-groups support only state=absent or state=present")
 			}
 			group.PatternType = strings.ToLower(group.PatternType)
 			if group.PatternType != "prefixed" && group.PatternType != "match" {
@@ -541,17 +926,10 @@ func applySpecFile() error {
 			var currentState = Ok
 			var currentError = ""
 			for currentGroupName, _ := range currentGroups {
-				var matched = false
-				if group.PatternType == "prefixed" {
-					matched = strings.HasPrefix(currentGroupName, group.Name)
-				} else if group.PatternType == "match" {
-					matched, _ = regexp.MatchString(group.Name, currentGroupName)
-				} else if currentGroupName == group.Name {
-					matched = true
-				}
-
-				if matched {
+				if patternMatches(currentGroupName, group.Name, group.PatternType) {
 					group.Matched = append(group.Matched, currentGroupName)
+					plan.Add(PlanOperation{Kind: "consumer-group", Name: currentGroupName, Action: ActionDelete,
+						Current: ConsumerGroup{Name: currentGroupName}})
 					err := DeleteConsumerGroup(currentGroupName, admin)
 					if err != nil {
 						numError++
@@ -576,66 +954,83 @@ func applySpecFile() error {
 		}
 	}
 
-	if len(spec.Acls) > 0 {
+	if prune {
+		// Delete consumer-groups which are not defined in the spec
+		for _, name := range groupsToPrune(spec.ConsumerGroups, currentGroups) {
+			fmt.Printf("TASK [CONSUMER-GROUP : Prune consumer-group %s] %s\n", name, strings.Repeat("*", 45))
+			group := ConsumerGroup{Name: name, State: "absent"}
+			plan.Add(PlanOperation{Kind: "consumer-group", Name: name, Action: ActionDelete, Current: ConsumerGroup{Name: name}})
+			err := DeleteConsumerGroup(name, admin)
+			if err != nil {
+				printResult(Error, broker, err.Error(), group)
+				numError++
+				if errorStop {
+					break
+				}
+				continue
+			}
+			printResult(Changed, broker, "", group)
+			numChanged++
+		}
+	}
 
-		// Get current ACLs from broker
-		currentAcls, err := listAllAcls(admin)
-		if err != nil {
-			return err
+	// Iterate over users
+	for _, user := range spec.Users {
+		result, err := alignUser(admin, currentUsers, user, actionCheck)
+		if result == Ok {
+			printResult(Ok, broker, "", user)
+			numOk++
+		} else if err != nil {
+			printResult(Error, broker, err.Error(), user)
+			numError++
+			if errorStop {
+				break
+			}
+		} else {
+			printResult(result, broker, "", user)
+			numChanged++
 		}
+	}
 
+	if len(spec.Acls) > 0 || prune {
 		// Iterate over ACLs
-		breakLoop := false
-		for _, acl := range spec.Acls {
-			principal := acl.Principal
-			for _, permission := range acl.Permissions {
-				resource := permission.Resource
-				for i, rule := range append(permission.Allow, permission.Deny...) {
-					sacl := SingleACL{
-						Principal: principal,
-						Resource:  resource,
-						Operation: getOperation(rule),
-						Host:      getHost(rule),
-					}
-					if permission.State == "absent" {
-						sacl.State = "absent"
-					} else {
-						sacl.State = "present"
-						// Host can be unset, we'll treat this as * for creating
-						if sacl.Host == "" {
-							sacl.Host = "*"
-						}
-					}
-					if i < len(permission.Allow) {
-						sacl.PermissionType = "ALLOW"
-					} else {
-						sacl.PermissionType = "DENY"
-					}
-
-					result, err := alignAcl(admin, &currentAcls, sacl)
-					if result == Ok {
-						printResult(Ok, broker, "", sacl)
-						numOk++
-					} else if err != nil {
-						printResult(Error, broker, err.Error(), sacl)
-						numError++
-						if errorStop {
-							breakLoop = true
-							break
-						}
-					} else {
-						printResult(result, broker, "", sacl)
-						numChanged++
-					}
-				}
-				if breakLoop {
+		sacls := expandAcls(spec.Acls)
+		if prune {
+			sacls = append(sacls, aclsToPrune(sacls, currentAcls)...)
+		}
+		for _, sacl := range sacls {
+			result, err := alignAcl(admin, &currentAcls, sacl)
+			if result == Ok {
+				printResult(Ok, broker, "", sacl)
+				numOk++
+			} else if err != nil {
+				printResult(Error, broker, err.Error(), sacl)
+				numError++
+				if errorStop {
 					break
 				}
+			} else {
+				printResult(result, broker, "", sacl)
+				numChanged++
 			}
-			if breakLoop {
+		}
+	}
+
+	// Iterate over quotas
+	for _, quota := range spec.Quotas {
+		result, err := alignQuota(admin, currentQuotas, quota)
+		if result == Ok {
+			printResult(Ok, broker, "", quota)
+			numOk++
+		} else if err != nil {
+			printResult(Error, broker, err.Error(), quota)
+			numError++
+			if errorStop {
 				break
 			}
-
+		} else {
+			printResult(result, broker, "", quota)
+			numChanged++
 		}
 	}
 	printSummary(broker, numOk, numChanged, numError)
@@ -653,14 +1048,7 @@ func alignAcl(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl Singl
 		action = "Remove"
 	}
 
-	if acl.Resource.Type == "cluster" {
-		if acl.Resource.Pattern == "" {
-			acl.Resource.Pattern = "kafka-cluster"
-		}
-	}
-	if acl.Resource.PatternType == "" {
-		acl.Resource.PatternType = "LITERAL"
-	}
+	acl = normalizeAcl(acl)
 
 	fmt.Printf("TASK [ACL : %s ACL (%s %s@%s to %s %s:%s:%s)] %s\n", action, acl.PermissionType, acl.Principal,
 		acl.Host, acl.Operation, acl.Resource.Type, acl.Resource.PatternType, acl.Resource.Pattern, strings.Repeat("*", 25))
@@ -675,20 +1063,22 @@ func alignAcl(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl Singl
 
 	if acl.State == "absent" {
 		// Won't check the presence. We'll just try do delete and see the length of MatchingAcl in response
-		filter := sarama.AclFilter{
-			ResourceType:              aclResourceTypeFromString(acl.Resource.Type),
-			ResourceName:              &acl.Resource.Pattern,
-			ResourcePatternTypeFilter: aclResourcePatternTypeFromString(acl.Resource.PatternType),
-			Operation:                 aclOperationFromString(acl.Operation),
-			PermissionType:            aclPermissionTypeFromString(acl.PermissionType),
-		}
-		if acl.Host != "" {
-			filter.Host = &acl.Host
+		filter := aclFilter(acl)
+		matched := matchingAcls(acls, filter)
+		for _, m := range matched {
+			plan.Add(PlanOperation{Kind: "acl", Name: m.String(), Action: ActionDelete, Current: m})
 		}
-		if acl.Principal != "*" {
-			filter.Principal = &acl.Principal
+		if dryRun {
+			if len(matched) > 0 {
+				return Changed, nil
+			}
+			return Ok, nil
 		}
-		mAcls, err := (*admin).DeleteACL(filter, false)
+		var mAcls []sarama.MatchingAcl
+		err := withRetry("DeleteACL "+acl.String(), func() (err error) {
+			mAcls, err = (*admin).DeleteACL(filter, false)
+			return err
+		})
 		if err != nil {
 			return Error, err
 		}
@@ -702,6 +1092,11 @@ func alignAcl(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl Singl
 		return Ok, nil
 	}
 
+	plan.Add(PlanOperation{Kind: "acl", Name: acl.String(), Action: ActionCreate, Desired: acl})
+	if dryRun {
+		return Changed, nil
+	}
+
 	r := sarama.Resource{
 		ResourceType:        aclResourceTypeFromString(acl.Resource.Type),
 		ResourceName:        acl.Resource.Pattern,
@@ -713,10 +1108,72 @@ func alignAcl(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl Singl
 		Operation:      aclOperationFromString(acl.Operation),
 		PermissionType: aclPermissionTypeFromString(acl.PermissionType),
 	}
-	err := (*admin).CreateACL(r, a)
+	err := withRetry("CreateACL "+acl.String(), func() error { return (*admin).CreateACL(r, a) })
 	return Changed, err
 }
 
+// expandAcls splits the ACLs from the spec into the list of single permissions
+func expandAcls(acls []Acl) []SingleACL {
+	var sacls []SingleACL
+	for _, acl := range acls {
+		for _, permission := range acl.Permissions {
+			for i, rule := range append(permission.Allow, permission.Deny...) {
+				sacl := SingleACL{
+					Principal: acl.Principal,
+					Resource:  permission.Resource,
+					Operation: getOperation(rule),
+					Host:      getHost(rule),
+				}
+				if permission.State == "absent" {
+					sacl.State = "absent"
+				} else {
+					sacl.State = "present"
+					// Host can be unset, we'll treat this as * for creating
+					if sacl.Host == "" {
+						sacl.Host = "*"
+					}
+				}
+				if i < len(permission.Allow) {
+					sacl.PermissionType = "ALLOW"
+				} else {
+					sacl.PermissionType = "DENY"
+				}
+				sacls = append(sacls, sacl)
+			}
+		}
+	}
+	return sacls
+}
+
+func normalizeAcl(acl SingleACL) SingleACL {
+	if acl.Resource.Type == "cluster" {
+		if acl.Resource.Pattern == "" {
+			acl.Resource.Pattern = "kafka-cluster"
+		}
+	}
+	if acl.Resource.PatternType == "" {
+		acl.Resource.PatternType = "LITERAL"
+	}
+	return acl
+}
+
+func aclFilter(acl SingleACL) sarama.AclFilter {
+	filter := sarama.AclFilter{
+		ResourceType:              aclResourceTypeFromString(acl.Resource.Type),
+		ResourceName:              &acl.Resource.Pattern,
+		ResourcePatternTypeFilter: aclResourcePatternTypeFromString(acl.Resource.PatternType),
+		Operation:                 aclOperationFromString(acl.Operation),
+		PermissionType:            aclPermissionTypeFromString(acl.PermissionType),
+	}
+	if acl.Host != "" {
+		filter.Host = &acl.Host
+	}
+	if acl.Principal != "*" {
+		filter.Principal = &acl.Principal
+	}
+	return filter
+}
+
 func aclExists(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl SingleACL) bool {
 	for _, resourceAcls := range *acls {
 		for _, currentAcl := range resourceAcls.Acls {
@@ -734,53 +1191,119 @@ func aclExists(admin *sarama.ClusterAdmin, acls *[]sarama.ResourceAcls, acl Sing
 	return false
 }
 
+// parseSpecFile reads the spec files defined by --spec and merges them into one spec
 func parseSpecFile() (Spec, error) {
+	paths, err := expandSpecPaths(specFiles)
+	if err != nil {
+		return Spec{}, err
+	}
+	var sources []specSource
+	var errs []string
+	for _, path := range paths {
+		content, err := readSpecSource(path)
+		if err != nil {
+			return Spec{}, err
+		}
+		spec, err := parseSpec(content, path)
+		if err != nil {
+			// The validation errors already start with the path
+			if _, invalid := err.(*specError); !invalid && len(paths) > 1 {
+				err = errors.New(path + ": " + err.Error())
+			}
+			errs = append(errs, err.Error())
+			continue
+		}
+		sources = append(sources, specSource{path: path, spec: spec})
+	}
+	if len(errs) > 0 {
+		return Spec{}, errors.New(strings.Join(errs, "\n"))
+	}
+	spec, err := mergeSpecs(sources)
+	if err != nil {
+		return spec, err
+	}
+	spec, err = resolveProfiles(spec)
+	if err != nil {
+		return spec, err
+	}
+	return applyOverlays(spec)
+}
+
+// parseSpec decodes the spec read from the path and expands its generators
+func parseSpec(specFile []byte, path string) (Spec, error) {
 	var spec Spec
-	specFile, err := ioutil.ReadFile(specfile)
+	err := decodeSpec(specFile, path, &spec)
 	if err != nil {
 		return spec, err
 	}
+	return expandGenerators(spec)
+}
 
+// decodeSpec renders the template, decrypts the values, validates and unmarshals the spec (or overlay) read from the path
+func decodeSpec(specFile []byte, path string, out interface{}) error {
+	var err error
 	if isTemplate {
-		t := template.New("")
-		if missingOk {
-			t, err = t.Parse(string(specFile))
-		} else {
-			t, err = t.Option("missingkey=error").Parse(string(specFile))
-		}
+		specFile, err = renderTemplate(specFile, path)
 		if err != nil {
-			return spec, err
+			return err
 		}
-		config := loadEnvMap()
-		var tpl bytes.Buffer
-		err = t.Execute(&tpl, config)
+	}
+
+	if _, isSpec := out.(*Spec); isSpec {
+		err = checkInlinePasswords(specFile)
 		if err != nil {
-			return spec, err
+			return err
 		}
-		specFile = tpl.Bytes()
+	}
+	if actionValidate {
+		specFile, err = checkEncryptedSpec(specFile)
+	} else {
+		specFile, err = decryptSpec(specFile)
+	}
+	if err != nil {
+		return err
 	}
 
+	format := specFormat(path)
 	if isYAML {
-		err = yaml.Unmarshal(specFile, &spec)
+		format = "yaml"
 	} else if isJSON {
-		err = json.Unmarshal(specFile, &spec)
-	} else {
-		err = yaml.Unmarshal(specFile, &spec)
-		if err != nil {
-			err = json.Unmarshal(specFile, &spec)
-		}
+		format = "json"
+	}
+	err = validateDocument(specFile, path, format, reflect.TypeOf(out).Elem())
+	if err != nil {
+		return err
 	}
-	return spec, err
+	if format == "json" {
+		return json.Unmarshal(specFile, out)
+	}
+	// JSON is valid YAML, so the spec of the unknown format is unmarshalled as YAML
+	return yaml.Unmarshal(specFile, out)
 }
 
-func alterNumPartitions(topic string, clusterAdmin *sarama.ClusterAdmin, count int) error {
+func alterNumPartitions(topic string, clusterAdmin *sarama.ClusterAdmin, count int, assignment [][]int32) error {
+	if dryRun {
+		return nil
+	}
 	admin := *clusterAdmin
-	err := admin.CreatePartitions(topic, int32(count), nil, false)
+	err := withRetry("CreatePartitions "+topic, func() error {
+		return admin.CreatePartitions(topic, int32(count), assignment, false)
+	})
 	return err
 }
 
 func alterTopicConfig(topic Topic, clusterAdmin *sarama.ClusterAdmin, currentConfig map[string]*string) (Topic, error) {
+	if dryRun {
+		return topic, nil
+	}
 	admin := *clusterAdmin
+	if !legacyAlterConfigs {
+		err := alterTopicConfigIncremental(topic, clusterAdmin)
+		if !errors.Is(err, sarama.ErrUnsupportedVersion) {
+			return topic, err
+		}
+		// Incremental updates require Kafka 2.3+, fall back to the full replacement of the config
+	}
 	configEntries := make(map[string]*string)
 	for key, val := range topic.Configs {
 		if val != "default" {
@@ -793,31 +1316,84 @@ func alterTopicConfig(topic Topic, clusterAdmin *sarama.ClusterAdmin, currentCon
 			topic.Configs[key] = *val
 		}
 	}
-	err := admin.AlterConfig(sarama.TopicResource, topic.Name, configEntries, false)
+	err := withRetry("AlterConfig "+topic.Name, func() error {
+		return admin.AlterConfig(sarama.TopicResource, topic.Name, configEntries, false)
+	})
 	return topic, err
 }
 
-func createTopic(topic Topic, admin *sarama.ClusterAdmin) error {
+// alterTopicConfigIncremental touches only the keys defined in the spec, the value "default" deletes the key
+func alterTopicConfigIncremental(topic Topic, clusterAdmin *sarama.ClusterAdmin) error {
+	admin := *clusterAdmin
+	configEntries := make(map[string]sarama.IncrementalAlterConfigsEntry)
+	for key, val := range topic.Configs {
+		if val == "default" {
+			configEntries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+		} else {
+			configEntries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: getPtr(val)}
+		}
+	}
+	return withRetry("IncrementalAlterConfig "+topic.Name, func() error {
+		return admin.IncrementalAlterConfig(sarama.TopicResource, topic.Name, configEntries, false)
+	})
+}
+
+func createTopic(topic Topic, admin *sarama.ClusterAdmin, assignment [][]int32) error {
+	if dryRun {
+		return nil
+	}
 	configEntries := make(map[string]*string)
 	for key, val := range topic.Configs {
 		if val != "default" {
 			configEntries[key] = getPtr(topic.Configs[key])
 		}
 	}
-	err := (*admin).CreateTopic(topic.Name, &sarama.TopicDetail{
+	detail := &sarama.TopicDetail{
 		NumPartitions:     int32(topic.Partitions),
 		ReplicationFactor: int16(topic.ReplicationFactor),
 		ConfigEntries:     configEntries,
-	}, false)
+	}
+	if assignment != nil {
+		// Number of partitions and replication-factor must be unset when the assignment is defined
+		detail.NumPartitions = -1
+		detail.ReplicationFactor = -1
+		detail.ReplicaAssignment = make(map[int32][]int32)
+		for partition, replicas := range assignment {
+			detail.ReplicaAssignment[int32(partition)] = replicas
+		}
+	}
+	attempts := 0
+	err := withRetry("CreateTopic "+topic.Name, func() error {
+		attempts++
+		return (*admin).CreateTopic(topic.Name, detail, false)
+	})
+	if attempts > 1 && errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		// The timed out attempt has created the topic
+		return nil
+	}
 	return err
 }
 
 func deleteTopic(topic string, admin *sarama.ClusterAdmin) error {
-	err := (*admin).DeleteTopic(topic)
+	if dryRun {
+		return nil
+	}
+	attempts := 0
+	err := withRetry("DeleteTopic "+topic, func() error {
+		attempts++
+		return (*admin).DeleteTopic(topic)
+	})
+	if attempts > 1 && errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		// The timed out attempt has deleted the topic
+		return nil
+	}
 	return err
 }
 
 func DeleteConsumerGroup(group string, admin *sarama.ClusterAdmin) error {
+	if dryRun {
+		return nil
+	}
 	err := (*admin).DeleteConsumerGroup(group)
 	return err
 }
@@ -833,6 +1409,9 @@ func printResult(color string, broker string, msg string, debug interface{}) {
 		status = "error"
 	}
 	jsonDebug := ""
+	if r, ok := debug.(redactor); ok {
+		debug = r.Redact()
+	}
 	if verbose {
 		json, _ := json.MarshalIndent(debug, "", "    ")
 		jsonDebug = string(json)
@@ -842,6 +1421,11 @@ func printResult(color string, broker string, msg string, debug interface{}) {
 
 func printSummary(broker string, numOk int, numChanged int, numError int) {
 	fmt.Printf("SUMMARY %s\n", strings.Repeat("*", 80))
+	lastSummary.ok, lastSummary.changed, lastSummary.failed = numOk, numChanged, numError
+	printSummaryCounters(numOk, numChanged, numError)
+}
+
+func printSummaryCounters(numOk int, numChanged int, numError int) {
 	if numOk > 0 {
 		fmt.Printf(Ok)
 	}
@@ -863,19 +1447,27 @@ func loadEnvVar(key string) string {
 	return ""
 }
 
-func loadEnvMap() map[string]string {
-	items := make(map[string]string)
-	for _, item := range append(os.Environ(), varFlags...) {
-		splits := strings.Split(item, "=")
-		key := splits[0]
-		val := strings.Join(splits[1:], "=")
-		items[key] = val
+func getPtr(s string) *string {
+	return &s
+}
+
+func countTrue(values ...bool) int {
+	var n int
+	for _, val := range values {
+		if val {
+			n++
+		}
 	}
-	return items
+	return n
 }
 
-func getPtr(s string) *string {
-	return &s
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (f *arrFlags) Set(s string) error {
@@ -889,13 +1481,53 @@ func (f *arrFlags) String() string {
 
 func validateFlags() {
 	flag.StringVar(&broker, "broker", "", "Bootstrap-brokers, default is localhost:9092 (can be also set by Env variable KAFKA_BROKER)")
-	flag.StringVar(&specfile, "spec", "", "Spec-file (can be set by Env variable KAFKA_SPEC_FILE)")
-	flag.StringVar(&protocol, "protocol", "plaintext", "Security protocol. Available options: plaintext, sasl_ssl, sasl_plaintext (default: plaintext)")
-	flag.StringVar(&mechanism, "mechanism", "scram-sha-256", "SASL mechanism. Available options: scram-sha-256, scram-sha-512 (default: scram-sha-256)")
+	flag.Var(&specFiles, "spec", "Spec-file, directory or - for stdin, can be repeated (can be set by Env variable KAFKA_SPEC_FILE)")
+	flag.Var(&overlayFiles, "overlay", "Overlay patching the spec, can be presented multiple times")
+	flag.StringVar(&protocol, "protocol", "plaintext", "Security protocol. Available options: plaintext, ssl, sasl_ssl, sasl_plaintext (default: plaintext)")
+	flag.StringVar(&mechanism, "mechanism", "scram-sha-256", "SASL mechanism. Available options: scram-sha-256, scram-sha-512, plain, gssapi, oauthbearer (default: scram-sha-256)")
 	flag.StringVar(&username, "username", "", "Username for authentication (can be also set by Env variable KAFKA_USERNAME")
 	flag.StringVar(&password, "password", "", "Password for authentication (can be also set by Env variable KAFKA_PASSWORD")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM file with the CA certificates for verifying the brokers (default: system CA)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM file with the client certificate for TLS authentication")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM file with the private key of the client certificate")
+	flag.StringVar(&tlsKeyPassword, "tls-key-password", "", "Passphrase of the encrypted private key (can be also set by Env variable KAFKA_TLS_KEY_PASSWORD)")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Server name for verifying the broker certificates (default: the broker host)")
+	flag.BoolVar(&tlsInsecure, "tls-insecure", false, "Do not verify the broker certificates")
+	flag.StringVar(&kerberosServiceName, "kerberos-service-name", "kafka", "Kerberos service name of the brokers (default: kafka)")
+	flag.StringVar(&kerberosRealm, "kerberos-realm", "", "Kerberos realm")
+	flag.StringVar(&kerberosKeytab, "kerberos-keytab", "", "Kerberos keytab file for the principal defined by --username")
+	flag.StringVar(&kerberosCcache, "kerberos-ccache", "", "Kerberos credentials cache file")
+	flag.StringVar(&kerberosConfig, "kerberos-config", "/etc/krb5.conf", "Kerberos config file (default: /etc/krb5.conf)")
+	flag.StringVar(&oauthTokenURL, "oauth-token-url", "", "OAuth token endpoint for oauthbearer mechanism")
+	flag.StringVar(&oauthClientID, "oauth-client-id", "", "OAuth client ID")
+	flag.StringVar(&oauthClientSecret, "oauth-client-secret", "", "OAuth client secret (can be also set by Env variable KAFKA_OAUTH_CLIENT_SECRET)")
+	flag.Var(&oauthScopes, "oauth-scope", "OAuth scope to request, can be presented multiple times")
+	flag.StringVar(&contextName, "context", "", "Context from the config file with the connection settings (default: current-context)")
+	flag.StringVar(&clusterSelection, "cluster", "", "Comma-separated clusters of the spec to run the action for, or all")
+	flag.StringVar(&encryptionKeyFile, "encryption-key-file", "", "File with the key for decrypting ENC[...] values of the spec")
+	flag.StringVar(&kafkaVersion, "kafka-version", "auto", "Kafka protocol version used for communicating with the brokers (default: auto)")
 	flag.BoolVar(&actionApply, "apply", false, "Apply spec-file to the broker, create all entities that do not exist there; this is the default action")
+	flag.BoolVar(&actionPlan, "plan", false, "Show the changes which --apply would make to the broker without applying them")
+	flag.BoolVar(&actionCheck, "check", false, "Check the drift between the spec and the broker, exit with code 3 if there is a drift")
+	flag.BoolVar(&actionRender, "render", false, "Print the merged spec without connecting to the cluster")
+	flag.BoolVar(&actionValidate, "validate", false, "Validate the spec without connecting to the cluster")
+	flag.BoolVar(&actionSchema, "schema", false, "Print the JSON Schema of the spec")
+	flag.StringVar(&planFile, "plan-file", "", "Save the plan to a JSON file (with --plan) or apply the saved plan (with --apply)")
+	flag.BoolVar(&prune, "prune", false, "Delete topics, consumer groups and ACLs which are not defined in the spec")
+	flag.StringVar(&prunePrefix, "prune-prefix", "", "Prune only topics and consumer groups with the prefix")
+	flag.StringVar(&pruneMatch, "prune-match", "", "Prune only topics and consumer groups matching the regex")
+	flag.Var(&prunePrincipals, "prune-principal", "Prune only ACLs of the principal")
+	flag.Var(&pruneIgnore, "prune-ignore", "Never prune the resources matching the regex")
 	flag.BoolVar(&actionDump, "dump", false, "Dump broker entities in YAML (default) or JSON format to stdout or to a file if --spec option is defined")
+	flag.BoolVar(&dumpDefaults, "dump-defaults", false, "Dump all the topic configs including the defaults")
+	flag.BoolVar(&legacyAlterConfigs, "legacy-alter-configs", false, "Replace the whole topic config with AlterConfigs instead of using IncrementalAlterConfigs")
+	flag.DurationVar(&reassignTimeout, "reassign-timeout", 30*time.Minute, "Timeout for the partition reassignment when changing the replication-factor")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 30*time.Second, "Timeout for establishing the connection to a broker")
+	flag.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "Timeout for sending a request to a broker and reading the response")
+	flag.DurationVar(&adminTimeout, "admin-timeout", 3*time.Second, "Time the controller is given to complete the admin operation")
+	flag.IntVar(&retries, "retries", 3, "Number of retries of the admin operations failed with retriable errors")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 500*time.Millisecond, "Delay before the first retry, doubled for every next one")
+	flag.DurationVar(&retryBackoffMax, "retry-backoff-max", 10*time.Second, "Maximum delay between the retries")
 	flag.BoolVar(&actionHelp, "help", false, "Print usage")
 	flag.BoolVar(&actionVersion, "version", false, "Show version")
 	flag.BoolVar(&isYAML, "yaml", false, "Spec-file is in YAML format (will try to detect format if none of --yaml or --json is set)")
@@ -905,25 +1537,79 @@ func validateFlags() {
 	flag.BoolVar(&missingOk, "missingok", false, "Ignore missing template keys")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
 	flag.Var(&varFlags, "var", "Variable for templating")
+	flag.Var(&valuesFiles, "values", "YAML or JSON file with the values for templating, can be presented multiple times")
 	flag.Usage = func() {
 		usage()
 	}
 	flag.Parse()
 
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if !actionHelp && !actionVersion && !actionRender && !actionValidate && !actionSchema {
+		conn, err := loadContext(contextName)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(usageExitCode())
+		}
+		applyContext(conn, explicit)
+	}
+
 	protocol = strings.ToLower(protocol)
 	mechanism = strings.ToLower(mechanism)
 
-	if !actionApply && !actionDump && !actionHelp && !actionVersion {
-		fmt.Println("Please define one of the actions: --dump, --apply, --help, --version")
-		os.Exit(1)
+	if !actionApply && !actionPlan && !actionCheck && !actionDump && !actionRender && !actionValidate && !actionSchema && !actionHelp && !actionVersion {
+		fmt.Println("Please define one of the actions: --dump, --apply, --plan, --check, --render, --validate, --schema, --help, --version")
+		os.Exit(usageExitCode())
 	}
-	if actionApply && actionDump {
-		fmt.Println("Please define one of the actions: --dump, --apply. Refer to kafka-ops --help for details")
-		os.Exit(1)
+	if countTrue(actionApply, actionPlan, actionCheck, actionDump, actionRender, actionValidate, actionSchema) > 1 {
+		fmt.Println("Please define one of the actions: --dump, --apply, --plan, --check, --render, --validate, --schema. Refer to kafka-ops --help for details")
+		os.Exit(usageExitCode())
+	}
+	if planFile != "" && !actionApply && !actionPlan {
+		fmt.Println("Option --plan-file can be used only with --plan or --apply actions")
+		os.Exit(usageExitCode())
+	}
+	if clusterSelection != "" && !actionApply && !actionPlan && !actionCheck && !actionRender && !actionValidate {
+		fmt.Println("Option --cluster can be used only with --plan, --check, --render, --validate or --apply actions")
+		os.Exit(usageExitCode())
+	}
+	if clusterSelection != "" && planFile != "" {
+		fmt.Println("Option --plan-file can't be used with --cluster, the plan is made for a single cluster")
+		os.Exit(usageExitCode())
+	}
+	if len(overlayFiles) > 0 && !actionApply && !actionPlan && !actionCheck && !actionRender && !actionValidate {
+		fmt.Println("Option --overlay can be used only with --plan, --check, --render, --validate or --apply actions")
+		os.Exit(usageExitCode())
+	}
+	if dumpDefaults && !actionDump {
+		fmt.Println("Option --dump-defaults can be used only with --dump action")
+		os.Exit(usageExitCode())
+	}
+	if prune && !actionApply && !actionPlan && !actionCheck {
+		fmt.Println("Option --prune can be used only with --plan, --check or --apply actions")
+		os.Exit(usageExitCode())
+	}
+	for _, pattern := range append([]string{pruneMatch}, pruneIgnore...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fmt.Println("Wrong prune regex " + pattern + ": " + err.Error())
+			os.Exit(usageExitCode())
+		}
+	}
+	if len(valuesFiles) > 0 && !isTemplate {
+		fmt.Println("Option --values can be used only with --template")
+		os.Exit(usageExitCode())
 	}
 	if isJSON && isYAML {
 		fmt.Println("Please define one of the formats: --json, --yaml")
-		os.Exit(1)
+		os.Exit(usageExitCode())
+	}
+	if dialTimeout <= 0 || readTimeout <= 0 || adminTimeout <= 0 {
+		fmt.Println("Options --dial-timeout, --read-timeout and --admin-timeout must be positive")
+		os.Exit(usageExitCode())
+	}
+	if retries < 0 || retryBackoff < 0 || retryBackoffMax < retryBackoff {
+		fmt.Println("Option --retries must not be negative and --retry-backoff-max must not be less than --retry-backoff")
+		os.Exit(usageExitCode())
 	}
 	if broker == "" {
 		broker = loadEnvVar("KAFKA_BROKER")
@@ -931,11 +1617,13 @@ func validateFlags() {
 			broker = "localhost:9092"
 		}
 	}
-	if specfile == "" {
-		specfile = loadEnvVar("KAFKA_SPEC_FILE")
-		if specfile == "" && actionApply {
+	if len(specFiles) == 0 {
+		if env := loadEnvVar("KAFKA_SPEC_FILE"); env != "" {
+			specFiles = arrFlags{env}
+		}
+		if len(specFiles) == 0 && (actionPlan || actionCheck || actionRender || actionValidate || (actionApply && planFile == "")) {
 			fmt.Println("Please define spec file with --spec option or with KAFKA_SPEC_FILE env variable")
-			os.Exit(1)
+			os.Exit(usageExitCode())
 		}
 	}
 	if protocol != "plaintext" {
@@ -945,7 +1633,31 @@ func validateFlags() {
 		if password == "" {
 			password = loadEnvVar("KAFKA_PASSWORD")
 		}
+		if oauthClientSecret == "" {
+			oauthClientSecret = loadEnvVar("KAFKA_OAUTH_CLIENT_SECRET")
+		}
+	}
+	if tlsKeyPassword == "" {
+		tlsKeyPassword = loadEnvVar("KAFKA_TLS_KEY_PASSWORD")
+	}
+}
+
+// usageExitCode returns the exit code for the wrong options, --check exits with 2 on any error
+// as 1 is not among its documented codes. The arguments are looked through as well, since
+// the flag parsing stops at the first wrong option
+func usageExitCode() int {
+	if actionCheck {
+		return 2
+	}
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		if name := strings.TrimLeft(arg, "-"); name == "check" || name == "check=true" {
+			return 2
+		}
 	}
+	return 1
 }
 
 func printVersion() error {
@@ -960,41 +1672,157 @@ Usage: %s <action> [<options>] [<broker connection options>]
     Actions
     --help           Show this help and exit
     --dump           Dump cluster resources and their configs to stdout
-                     See also --json and --yaml options
+                     Only the per-topic config overrides are dumped
+                     See also --json, --yaml and --dump-defaults options
     --apply          Idempotently align cluster resources with the spec manifest
                      See also --spec, --json and --yaml options
+    --plan           Show the operations which --apply would perform (with the current
+                     and desired values) without changing anything in the cluster
+    --check          Check the drift between the spec manifest and the cluster without
+                     changing anything. Exits with code 0 if the cluster is in sync,
+                     3 if there is a drift and 2 on errors
+    --render         Print the merged spec (see --spec) without connecting to the
+                     cluster. The secrets are not printed. With --cluster the spec
+                     of the cluster is printed. See also --json and --yaml options
+    --validate       Validate the spec manifest (see --spec) without connecting to the
+                     cluster: unknown fields, wrong values, conflicts between the spec
+                     files, profiles and the overrides of the clusters (all of them
+                     or the ones selected by --cluster). Exits with code 2 if invalid
+    --schema         Print the JSON Schema of the spec manifest
     --version        Show version
+    config list      List the contexts of the config file
+    config use <context>
+                     Set the current context
+    config show [<context>]
+                     Show the settings of the context (current one by default)
+    encrypt [<value>]
+                     Encrypt the value (or stdin) to ENC[...] form for the spec
+                     With --generate-key print a new random encryption key
+    decrypt [<value>]
+                     Decrypt the value (or stdin) in ENC[...] form
     ----------------
     Options
+    --plan-file      With --plan: save the plan as a JSON document to the file
+                     With --apply: apply the saved plan instead of --spec. The apply
+                     is refused if the cluster has changed since the plan was made
     --spec           A path to manifest (specification file) to be used
-                     with --apply action
+                     with --apply, --plan, --check, --render and --validate actions.
+                     Can be presented multiple times, the specs are merged. A directory
+                     is read recursively (*.yaml, *.yml and *.json), - stands for stdin
                      Can be also set by Env variable KAFKA_SPEC_FILE
+    --overlay        A path to overlay which patches the merged spec: changes topics
+                     by name, adds and removes ACL permissions. Can be presented
+                     multiple times, the overlays are applied in order
+    --cluster        Comma-separated names of the clusters from the clusters section
+                     of the spec (or all) to run --apply, --plan, --check, --render or
+                     --validate for. The action is run once per cluster with its
+                     connection settings and topic overrides, the per-cluster summary
+                     is printed at the end
+    --dump-defaults  With --dump: include all the topic configs, also the ones inherited
+                     from the broker config and the defaults
+    --prune          Treat the spec as the full truth: delete topics, consumer groups and
+                     ACLs which are not defined in it. Topics starting with __ are
+                     never pruned. Consider running --plan first
+    --prune-prefix   Prune only topics and consumer groups starting with the prefix
+    --prune-match    Prune only topics and consumer groups matching the regex
+    --prune-principal
+                     Prune only ACLs of the principal. Can be presented multiple times
+    --prune-ignore   Never prune topics, consumer groups and ACL resources matching
+                     the regex. Can be presented multiple times
     --yaml           Spec-file is in YAML format
-                     Will try to detect format if none of --yaml or --json is set
+                     Will detect format by the file extension or by the content
+                     if none of --yaml or --json is set
     --json           Spec-file is in JSON format
-                     Will try to detect format if none of --yaml or --json is set
+                     Will detect format by the file extension or by the content
+                     if none of --yaml or --json is set
     --template       Spec-file is a Go-template to be parsed. The values are read from
-                     Env variables and from --var arguments (--var arguments are
-                     taking precedence)
+                     Env variables, from --values files and from --var arguments (each
+                     one taking precedence over the previous ones)
+    --values         YAML or JSON file with the (nested) values for the template
+                     Can be presented multiple times, the files are merged in order
     --var            Variable in format "key=value". Can be presented multiple times
     --missingok      Do not fail if template key is not defined
+    --encryption-key-file
+                     File with the base64-encoded 256-bit key for the ENC[...] values
+                     of the spec and for encrypt/decrypt actions
+                     Can be also set by Env variables KAFKA_OPS_ENCRYPTION_KEY (the
+                     key itself) or KAFKA_OPS_ENCRYPTION_KEY_FILE
+    --legacy-alter-configs
+                     Replace the whole topic config with AlterConfigs API instead of
+                     changing only the keys from the spec with IncrementalAlterConfigs.
+                     The legacy mode is always used with --kafka-version older than 2.3.0
+    --reassign-timeout
+                     How long to wait for the partition reassignment when the
+                     replication-factor of the topic is changed. Default is 30m
+    --retries        How many times to retry creating, deleting and altering topics
+                     and ACLs when they fail with retriable errors (e.g. NOT_CONTROLLER
+                     or REQUEST_TIMED_OUT during the rolling restart). Default is 3
+    --retry-backoff  Delay before the first retry, doubled for every next one.
+                     Default is 500ms
+    --retry-backoff-max
+                     Maximum delay between the retries. Default is 10s
     --verbose        Verbose output
     --stop-on-error  Exit on first occurred error
     ----------------
     Broker connection options
+    --context        Context with the connection settings from the config file
+                     (KAFKA_OPS_CONFIG or ~/.config/kafka-ops/config.yaml). Default
+                     is the current-context. Flags and Env variables take precedence
     --broker         Bootstrap-brokers, comma-separated. Default is localhost:9092
                      Can be also set by Env variable KAFKA_BROKER
     --protocol       Security protocol. Default is plaintext
-                     Available options: plaintext, sasl_ssl, sasl_plaintext
+                     Available options: plaintext, ssl, sasl_ssl, sasl_plaintext
     --mechanism      SASL mechanism. Default is scram-sha-256
-                     Available options: scram-sha-256, scram-sha-512
+                     Available options: scram-sha-256, scram-sha-512, plain, gssapi,
+                     oauthbearer
     --username       Username for authentication
                      Can be also set by Env variable KAFKA_USERNAME
     --password       Password for authentication
                      Can be also set by Env variable KAFKA_PASSWORD
+    --tls-ca         PEM file with the CA certificates the broker certificates are
+                     verified against. Default is the system CA pool
+    --tls-cert       PEM file with the client certificate for TLS authentication
+    --tls-key        PEM file with the private key of the client certificate
+    --tls-key-password
+                     Passphrase of the encrypted private key
+                     Can be also set by Env variable KAFKA_TLS_KEY_PASSWORD
+    --tls-server-name
+                     Server name the broker certificates are verified against.
+                     Default is the host of the broker
+    --tls-insecure   Do not verify the broker certificates. Not recommended
+    --kerberos-service-name
+                     Kerberos service name of the brokers. Default is kafka
+    --kerberos-realm Kerberos realm of the principal defined by --username
+    --kerberos-keytab
+                     Keytab file for the principal defined by --username. Without
+                     keytab and ccache --password is used
+    --kerberos-ccache
+                     Kerberos credentials cache file (e.g. after kinit)
+    --kerberos-config
+                     Kerberos config file. Default is /etc/krb5.conf
+    --oauth-token-url
+                     OAuth token endpoint for oauthbearer mechanism. The token is
+                     requested with client credentials grant and refreshed before
+                     it expires
+    --oauth-client-id
+                     OAuth client ID
+    --oauth-client-secret
+                     OAuth client secret
+                     Can be also set by Env variable KAFKA_OAUTH_CLIENT_SECRET
+    --oauth-scope    OAuth scope to request. Can be presented multiple times
+    --dial-timeout   Timeout for establishing the connection to a broker. Default is 30s
+    --read-timeout   Timeout for sending a request to a broker and reading the
+                     response. Default is 30s
+    --admin-timeout  Time the controller is given to complete the admin operation,
+                     e.g. the topic creation. Default is 3s
+    --kafka-version  Kafka protocol version used for communicating with the brokers.
+                     Default is auto: the highest version supported by the brokers
+                     is detected with ApiVersions request. Incremental config updates
+                     require 2.3.0+, changing the replication-factor requires 2.4.0+,
+                     quotas require 2.6.0+ and users require 2.7.0+
 `
 
 	fmt.Fprintf(os.Stderr, usage, os.Args[0])
 	//flag.PrintDefaults()
-	os.Exit(1)
+	os.Exit(usageExitCode())
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"github.com/IBM/sarama"
 
-	"testing"
 	"bytes"
 	"io"
 	"io/ioutil"
@@ -12,8 +11,15 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"testing"
 )
 
+// TestMain pins the Kafka version, the mock brokers don't answer the ApiVersions requests of the auto-detection
+func TestMain(m *testing.M) {
+	kafkaVersion = "2.2.0"
+	os.Exit(m.Run())
+}
+
 func captureOutput(f func() error) (string, error) {
 	reader, writer, err := os.Pipe()
 	if err != nil {
@@ -114,7 +120,7 @@ func TestApplySpecFile(t *testing.T) {
 
 	protocol = "plaintext"
 	broker = seedBroker.Addr()
-	specfile = "testdata/apply_spec.yaml"
+	specFiles = arrFlags{"testdata/apply_spec.yaml"}
 	out, err := captureOutput(func() error { return applySpecFile() })
 
 	if err != nil {
@@ -159,7 +165,7 @@ func TestApplySpecFileTemplate(t *testing.T) {
 	varFlags = append(varFlags, "Broker="+seedBroker.Addr())
 	varFlags = append(varFlags, "Topic=my")
 	isTemplate = true
-	specfile = "testdata/apply_spec_template.yaml"
+	specFiles = arrFlags{"testdata/apply_spec_template.yaml"}
 	out, err := captureOutput(func() error { return applySpecFile() })
 
 	if err != nil {
@@ -197,7 +203,7 @@ func TestApplySpecFileAlterTopic(t *testing.T) {
 
 	isYAML = true
 	broker = seedBroker.Addr()
-	specfile = "testdata/apply_spec_alter_topic.yaml"
+	specFiles = arrFlags{"testdata/apply_spec_alter_topic.yaml"}
 	isTemplate = false
 	verbose = true
 	out, err := captureOutput(func() error { return applySpecFile() })
@@ -218,6 +224,39 @@ func TestApplySpecFileAlterTopic(t *testing.T) {
 	}
 }
 
+func TestApplySpecFileIncrementalAlterConfig(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	// No handler for AlterConfigsRequest: the legacy full replacement would fail
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"ApiVersionsRequest":             sarama.NewMockApiVersionsResponse(t),
+		"DescribeConfigsRequest":         sarama.NewMockDescribeConfigsResponse(t),
+		"IncrementalAlterConfigsRequest": sarama.NewMockIncrementalAlterConfigsResponse(t),
+		"CreatePartitionsRequest":        sarama.NewMockCreatePartitionsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_alter_topic.yaml"}
+	isTemplate = false
+	verbose = false
+	kafkaVersion = "2.3.0"
+	defer func() { kafkaVersion = "2.2.0" }()
+	out, err := captureOutput(func() error { return applySpecFile() })
+
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "changed=1") {
+		t.Fatalf("Output does not contain expected \"changed=1\":\n%s", out)
+	}
+}
+
 func TestApplySpecFileDeleteAcl(t *testing.T) {
 	seedBroker := sarama.NewMockBroker(t, 2)
 	defer seedBroker.Close()
@@ -240,7 +279,7 @@ func TestApplySpecFileDeleteAcl(t *testing.T) {
 	mechanism = "scram-sha-256"
 	username = "test"
 	password = "test"
-	specfile = "testdata/apply_spec_delete_acl.yaml"
+	specFiles = arrFlags{"testdata/apply_spec_delete_acl.yaml"}
 	isTemplate = false
 	verbose = false
 	out, err := captureOutput(func() error { return applySpecFile() })
@@ -281,7 +320,7 @@ func TestApplySpecFileDeleteByPattern(t *testing.T) {
 
 	protocol = "plaintext"
 	broker = seedBroker.Addr()
-	specfile = "testdata/apply_spec_delete_by_pattern.yaml"
+	specFiles = arrFlags{"testdata/apply_spec_delete_by_pattern.yaml"}
 	verbose = true
 	out, err := captureOutput(func() error { return applySpecFile() })
 
@@ -328,3 +367,23 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("Version output %s does not match the expected %s", trimOut, expected)
 	}
 }
+
+func TestUsageExitCode(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	var tests = []struct {
+		args []string
+		code int
+	}{
+		{[]string{"kafka-ops", "--plan", "--bogus"}, 1},
+		{[]string{"kafka-ops", "--bogus", "--check"}, 2},
+		{[]string{"kafka-ops", "-check=true", "--bogus"}, 2},
+		{[]string{"kafka-ops", "--plan", "--", "--check"}, 1},
+	}
+	for _, tt := range tests {
+		os.Args = tt.args
+		if code := usageExitCode(); code != tt.code {
+			t.Errorf("usageExitCode for %v must be %d, got %d", tt.args, tt.code, code)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Overlay patches the spec for a particular environment: the topics are patched by name,
+// the ACL permissions are added or removed
+type Overlay struct {
+	Topics []TopicPatch `yaml:"topics,omitempty" json:"topics,omitempty"`
+	Acls   AclPatch     `yaml:"acls,omitempty" json:"acls,omitempty"`
+}
+
+// TopicPatch changes the fields of the topic defined in the spec, the unset fields are kept
+type TopicPatch struct {
+	Name              string       `yaml:"name" json:"name"`
+	Partitions        int          `yaml:"partitions,omitempty" json:"partitions,omitempty"`
+	ReplicationFactor int          `yaml:"replication_factor,omitempty" json:"replication_factor,omitempty"`
+	Configs           TopicConfigs `yaml:"configs,omitempty" json:"configs,omitempty"`
+	UnsetConfigs      []string     `yaml:"unset_configs,omitempty" json:"unset_configs,omitempty"`
+	State             string       `yaml:"state,omitempty" json:"state,omitempty"`
+}
+
+// AclPatch lists the ACL permissions to add to the spec and to remove from it
+type AclPatch struct {
+	Add    []Acl `yaml:"add,omitempty" json:"add,omitempty"`
+	Remove []Acl `yaml:"remove,omitempty" json:"remove,omitempty"`
+}
+
+// applyOverlays patches the spec with the overlays defined by --overlay, in order
+func applyOverlays(spec Spec) (Spec, error) {
+	for _, path := range overlayFiles {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return spec, err
+		}
+		var overlay Overlay
+		err = decodeSpec(content, path, &overlay)
+		if err != nil {
+			if _, invalid := err.(*specError); !invalid {
+				err = errors.New(path + ": " + err.Error())
+			}
+			return spec, err
+		}
+		spec, err = applyOverlay(spec, overlay)
+		if err != nil {
+			return spec, errors.New(path + ": " + err.Error())
+		}
+	}
+	return spec, nil
+}
+
+// applyOverlay patches the topics and the ACLs of the spec. The patch of a topic, config or ACL
+// which is not defined in the spec is an error, all the errors are reported at once
+func applyOverlay(spec Spec, overlay Overlay) (Spec, error) {
+	var errs []string
+	topics := append([]Topic{}, spec.Topics...)
+	for _, patch := range overlay.Topics {
+		found := false
+		for i, topic := range topics {
+			if topic.Name != patch.Name || (topic.PatternType != "" && !strings.EqualFold(topic.PatternType, "literal")) {
+				continue
+			}
+			found = true
+			topic, err := patchTopic(topic, patch)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			topics[i] = topic
+		}
+		if !found {
+			errs = append(errs, "Topic "+patch.Name+" is not defined in the spec")
+		}
+	}
+	spec.Topics = topics
+
+	acls, err := removeAcls(spec.Acls, overlay.Acls.Remove)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	declared := make(map[string]bool)
+	for _, sacl := range expandAcls(acls) {
+		declared[aclKey(sacl)] = true
+	}
+	for _, sacl := range expandAcls(overlay.Acls.Add) {
+		if declared[aclKey(sacl)] {
+			errs = append(errs, "ACL "+sacl.String()+" is already defined in the spec, remove it first to change it")
+		}
+	}
+	spec.Acls = append(acls, overlay.Acls.Add...)
+
+	if len(errs) > 0 {
+		return spec, errors.New(strings.Join(errs, "\n"))
+	}
+	return spec, nil
+}
+
+func patchTopic(topic Topic, patch TopicPatch) (Topic, error) {
+	if patch.Partitions > 0 {
+		topic.Partitions = patch.Partitions
+	}
+	if patch.ReplicationFactor > 0 {
+		topic.ReplicationFactor = patch.ReplicationFactor
+	}
+	if patch.State != "" {
+		topic.State = patch.State
+	}
+	configs := copyConfigs(topic.Configs)
+	if configs == nil && len(patch.Configs) > 0 {
+		configs = make(map[string]string)
+	}
+	for key, val := range patch.Configs {
+		configs[key] = val
+	}
+	var missing []string
+	for _, key := range patch.UnsetConfigs {
+		if _, found := configs[key]; !found {
+			missing = append(missing, key)
+		}
+		delete(configs, key)
+	}
+	topic.Configs = configs
+	if len(missing) > 0 {
+		return topic, fmt.Errorf("Configs %s of topic %s are not defined in the spec", strings.Join(missing, ", "), topic.Name)
+	}
+	return topic, nil
+}
+
+// removeAcls removes the operations of the permissions from the ACLs. The permissions and ACLs left
+// without operations are removed too
+func removeAcls(acls []Acl, remove []Acl) ([]Acl, error) {
+	removed := make(map[string]bool)
+	for _, sacl := range expandAcls(remove) {
+		removed[aclKey(sacl)] = false
+	}
+	var result []Acl
+	for _, acl := range acls {
+		var permissions []Permission
+		for _, permission := range acl.Permissions {
+			keep := func(rules []string, deny bool) []string {
+				var kept []string
+				for _, rule := range rules {
+					single := Permission{Resource: permission.Resource, State: permission.State}
+					if deny {
+						single.Deny = []string{rule}
+					} else {
+						single.Allow = []string{rule}
+					}
+					key := aclKey(expandAcls([]Acl{{Principal: acl.Principal, Permissions: []Permission{single}}})[0])
+					if _, found := removed[key]; found {
+						removed[key] = true
+						continue
+					}
+					kept = append(kept, rule)
+				}
+				return kept
+			}
+			permission.Allow = keep(permission.Allow, false)
+			permission.Deny = keep(permission.Deny, true)
+			if len(permission.Allow) > 0 || len(permission.Deny) > 0 {
+				permissions = append(permissions, permission)
+			}
+		}
+		if len(permissions) > 0 {
+			acl.Permissions = permissions
+			result = append(result, acl)
+		}
+	}
+	var errs []string
+	for _, sacl := range expandAcls(remove) {
+		if !removed[aclKey(sacl)] {
+			errs = append(errs, "ACL "+sacl.String()+" is not defined in the spec")
+		}
+	}
+	if len(errs) > 0 {
+		return result, errors.New(strings.Join(errs, "\n"))
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSpecFileOverlay(t *testing.T) {
+	specFiles = arrFlags{"testdata/apply_spec.yaml"}
+	overlayFiles = arrFlags{"testdata/overlays/prod.yaml"}
+	isTemplate = false
+	defer func() { specFiles, overlayFiles = nil, nil }()
+
+	spec, err := parseSpecFile()
+	if err != nil {
+		t.Fatal("Failed to parse spec file: " + err.Error())
+	}
+	topic := spec.Topics[1]
+	expectedConfigs := TopicConfigs{"cleanup.policy": "compact", "min.insync.replicas": "1", "retention.ms": "604800000"}
+	if topic.Name != "my_topic1" || topic.Partitions != 6 || !reflect.DeepEqual(topic.Configs, expectedConfigs) {
+		t.Errorf("Wrong patched topic: %+v", topic)
+	}
+	if spec.Topics[4].State != "present" || spec.Topics[2].Partitions != 3 {
+		t.Errorf("Wrong topics after the overlay: %+v", spec.Topics)
+	}
+
+	var acls []string
+	for _, sacl := range expandAcls(spec.Acls) {
+		acls = append(acls, sacl.String())
+	}
+	expected := []string{
+		"ALLOW User:test1@* to READ topic:PREFIXED:my-",
+		"ALLOW User:test1@* to DESCRIBE topic:PREFIXED:my-",
+		"ALLOW User:test1@* to READ group:LITERAL:my-group",
+		"ALLOW User:prod-monitoring@* to DESCRIBE topic:PREFIXED:my-",
+	}
+	if !reflect.DeepEqual(acls, expected) {
+		t.Errorf("Wrong ACLs after the overlay:\n%s", strings.Join(acls, "\n"))
+	}
+
+	// All the patches of the undefined resources are reported at once
+	overlayFiles = arrFlags{"testdata/overlays/invalid.yaml"}
+	_, err = parseSpecFile()
+	for _, str := range []string{
+		"testdata/overlays/invalid.yaml: Topic my_topic9 is not defined in the spec",
+		"Configs cleanup.policy of topic my_topic2 are not defined in the spec",
+		"ACL ALLOW User:test2@* to READ topic:PREFIXED:my- is not defined in the spec",
+		"ACL ALLOW User:test1@* to READ group:LITERAL:my-group is already defined in the spec",
+	} {
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("Error \"%s\" is not reported: %v", str, err)
+		}
+	}
+
+	// The overlay is validated like the spec
+	overlayFiles = arrFlags{t.TempDir() + "/overlay.yaml"}
+	_, err = parseSpecFile()
+	if err == nil {
+		t.Errorf("parseSpecFile must fail for the missing overlay")
+	}
+	err = validateDocument([]byte("topics:\n- name: a\n  partition: 2\n"), "overlay.yaml", "", reflect.TypeOf(Overlay{}))
+	if err == nil || !strings.Contains(err.Error(), `overlay.yaml:3:3: unknown field "partition" in TopicPatch, did you mean "partitions"?`) {
+		t.Errorf("validateDocument must fail for the unknown field of the overlay: %v", err)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Plan contains the list of operations which --apply would perform on the cluster
+type Plan struct {
+	Version     string          `json:"version"`
+	Broker      string          `json:"broker"`
+	Fingerprint string          `json:"fingerprint"`
+	Spec        Spec            `json:"spec"`
+	Prune       *PruneOptions   `json:"prune,omitempty"`
+	Operations  []PlanOperation `json:"operations"`
+}
+
+// PruneOptions keeps the prune settings the plan was made with
+type PruneOptions struct {
+	Prefix     string   `json:"prefix,omitempty"`
+	Match      string   `json:"match,omitempty"`
+	Principals arrFlags `json:"principals,omitempty"`
+	Ignore     arrFlags `json:"ignore,omitempty"`
+}
+
+// PlanOperation describes a single planned change of a cluster resource
+type PlanOperation struct {
+	Kind    string      `json:"kind"`
+	Name    string      `json:"name"`
+	Key     string      `json:"key,omitempty"`
+	Action  string      `json:"action"`
+	Current interface{} `json:"current,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+// The planned actions
+const (
+	ActionCreate = "create"
+	ActionAlter  = "alter"
+	ActionDelete = "delete"
+)
+
+// dryRun disables all the admin write calls, the changes are only recorded to the plan
+var dryRun bool
+
+// plan collects the operations of the current run
+var plan Plan
+
+// Add records the operation to the plan
+func (p *Plan) Add(op PlanOperation) {
+	p.Operations = append(p.Operations, op)
+}
+
+// Count returns the number of operations with the given action
+func (p *Plan) Count(action string) int {
+	var n int
+	for _, op := range p.Operations {
+		if op.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (op PlanOperation) String() string {
+	var sign string
+	switch op.Action {
+	case ActionCreate:
+		sign = "+"
+	case ActionAlter:
+		sign = "~"
+	case ActionDelete:
+		sign = "-"
+	}
+	name := op.Name
+	if op.Key != "" {
+		name += " " + op.Key
+	}
+	s := fmt.Sprintf("%s %s %s %s", sign, op.Action, op.Kind, name)
+	if op.Current != nil || op.Desired != nil {
+		s += ": " + planValue(op.Current) + " => " + planValue(op.Desired)
+	}
+	return s
+}
+
+func planValue(val interface{}) string {
+	if val == nil {
+		return "(none)"
+	}
+	if s, ok := val.(string); ok {
+		return s
+	}
+	out, _ := json.Marshal(val)
+	return string(out)
+}
+
+// planSpecFile parses the spec and prints the plan of the changes
+func planSpecFile() error {
+	spec, err := parseSpecFile()
+	if err != nil {
+		return errors.New("Can't parse spec manifest: " + err.Error())
+	}
+	return planSpec(spec)
+}
+
+// planSpec prints the changes applying the spec would make, the plan is saved if --plan-file is set
+func planSpec(spec Spec) error {
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	err := applySpec(spec, nil)
+	if err != nil {
+		return err
+	}
+	printPlan()
+	if planFile != "" {
+		err = writePlanFile(planFile)
+		if err != nil {
+			return errors.New("Can't write plan file: " + err.Error())
+		}
+		fmt.Printf(" The plan is saved to %s\n", planFile)
+	}
+	return nil
+}
+
+func writePlanFile(path string) error {
+	out, err := json.MarshalIndent(plan, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0600)
+}
+
+// applyPlanFile applies the saved plan. The operations are recomputed first without changing the cluster
+// and must be the same as the saved ones, so what was reviewed is what gets applied
+func applyPlanFile() error {
+	savedPlan, err := readPlanFile(planFile)
+	if err != nil {
+		return errors.New("Can't read plan file: " + err.Error())
+	}
+	operations, err := recomputeOperations(savedPlan)
+	if err != nil {
+		return err
+	}
+	if diff := diffOperations(savedPlan.Operations, operations); diff != "" {
+		return errors.New("The operations differ from the saved plan: " + diff + ". Please re-run --plan")
+	}
+	return applySpec(savedPlan.Spec, &savedPlan)
+}
+
+// recomputeOperations runs the saved plan in the dry-run mode with the output discarded
+func recomputeOperations(savedPlan Plan) ([]PlanOperation, error) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	dryRun = true
+	defer func() {
+		os.Stdout = stdout
+		dryRun = false
+	}()
+
+	err = applySpec(savedPlan.Spec, &savedPlan)
+	if err != nil && err.Error() == "" {
+		return nil, errors.New("Some of the planned operations fail. Please re-run --plan to see the errors")
+	}
+	return plan.Operations, err
+}
+
+// diffOperations describes the first difference between the saved and the recomputed operations.
+// The operations are compared in JSON, as the values of the saved ones are decoded into generic types
+func diffOperations(saved []PlanOperation, operations []PlanOperation) string {
+	for i := 0; i < len(saved) || i < len(operations); i++ {
+		if i >= len(operations) {
+			return "the saved operation \"" + saved[i].String() + "\" is not needed anymore"
+		}
+		if i >= len(saved) {
+			return "the operation \"" + operations[i].String() + "\" is not in the saved plan"
+		}
+		if canonicalJSON(saved[i]) != canonicalJSON(operations[i]) {
+			return "expected \"" + saved[i].String() + "\", got \"" + operations[i].String() + "\""
+		}
+	}
+	return ""
+}
+
+// canonicalJSON encodes the value with the object keys sorted, both for the structs and the maps
+func canonicalJSON(val interface{}) string {
+	var generic interface{}
+	out, _ := json.Marshal(val)
+	_ = json.Unmarshal(out, &generic)
+	out, _ = json.Marshal(generic)
+	return string(out)
+}
+
+func readPlanFile(path string) (Plan, error) {
+	var p Plan
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(in, &p)
+	if err != nil {
+		return p, err
+	}
+	if p.Fingerprint == "" {
+		return p, errors.New("the plan has no cluster fingerprint")
+	}
+	return p, nil
+}
+
+// clusterFingerprint calculates the checksum of the cluster state read by applySpecFile
+func clusterFingerprint(topics map[string]sarama.TopicDetail, groups map[string]string, acls []sarama.ResourceAcls,
+	quotas []Quota, users []User) string {
+	var state struct {
+		Topics map[string]sarama.TopicDetail `json:"topics"`
+		Groups []string                      `json:"groups"`
+		Acls   []string                      `json:"acls"`
+		Quotas []Quota                       `json:"quotas,omitempty"`
+		Users  []User                        `json:"users,omitempty"`
+	}
+	state.Topics = topics
+	for name := range groups {
+		state.Groups = append(state.Groups, name)
+	}
+	sort.Strings(state.Groups)
+	for _, resourceAcls := range acls {
+		for _, acl := range resourceAcls.Acls {
+			state.Acls = append(state.Acls, singleACLFromSarama(resourceAcls.Resource, acl).String())
+		}
+	}
+	sort.Strings(state.Acls)
+	state.Quotas = quotas
+	state.Users = users
+	out, _ := json.Marshal(state)
+	return fmt.Sprintf("%x", sha256.Sum256(out))
+}
+
+// checkSpecFile parses the spec, compares it with the cluster and reports whether there is a drift
+func checkSpecFile() (bool, error) {
+	spec, err := parseSpecFile()
+	if err != nil {
+		return false, errors.New("Can't parse spec manifest: " + err.Error())
+	}
+	return checkSpec(spec)
+}
+
+// checkSpec compares the spec with the cluster and reports whether there is a drift
+func checkSpec(spec Spec) (bool, error) {
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	err := applySpec(spec, nil)
+	if err != nil {
+		return false, err
+	}
+	printDrift()
+	return len(plan.Operations) > 0, nil
+}
+
+func printDrift() {
+	fmt.Printf("DRIFT %s\n", strings.Repeat("*", 82))
+	if len(plan.Operations) == 0 {
+		fmt.Println(" No drift. The cluster is in sync with the spec")
+		return
+	}
+	for _, op := range plan.Operations {
+		fmt.Printf(Changed+" %s\n"+Default, op.Drift())
+	}
+	fmt.Printf(" drifted=%d\n", len(plan.Operations))
+}
+
+// Drift describes the difference between the cluster and the spec
+func (op PlanOperation) Drift() string {
+	switch op.Kind {
+	case "partitions":
+		return fmt.Sprintf("topic %s partitions: %s (spec: %s)", op.Name, planValue(op.Current), planValue(op.Desired))
+	case "replication-factor":
+		return fmt.Sprintf("topic %s replication-factor: %s (spec: %s)", op.Name, planValue(op.Current), planValue(op.Desired))
+	case "config":
+		if op.Action == ActionCreate {
+			return fmt.Sprintf("topic %s config %s: not set (spec: %s)", op.Name, op.Key, planValue(op.Desired))
+		}
+		if op.Action == ActionDelete {
+			return fmt.Sprintf("topic %s config %s: %s (spec: default)", op.Name, op.Key, planValue(op.Current))
+		}
+		return fmt.Sprintf("topic %s config %s: %s (spec: %s)", op.Name, op.Key, planValue(op.Current), planValue(op.Desired))
+	case "user":
+		if op.Action == ActionCreate {
+			return fmt.Sprintf("user %s %s is missing", op.Name, op.Key)
+		}
+		if op.Action == ActionDelete {
+			return fmt.Sprintf("user %s %s must be absent", op.Name, op.Key)
+		}
+		return fmt.Sprintf("user %s %s differs: %s (spec: %s)", op.Name, op.Key, planValue(op.Current), planValue(op.Desired))
+	case "quota":
+		if op.Action == ActionCreate {
+			return fmt.Sprintf("quota %s %s: not set (spec: %s)", op.Name, op.Key, planValue(op.Desired))
+		}
+		if op.Action == ActionDelete {
+			return fmt.Sprintf("quota %s %s: %s (spec: absent)", op.Name, op.Key, planValue(op.Current))
+		}
+		return fmt.Sprintf("quota %s %s: %s (spec: %s)", op.Name, op.Key, planValue(op.Current), planValue(op.Desired))
+	}
+	kind := op.Kind
+	if kind == "acl" {
+		kind = "ACL"
+	}
+	switch op.Action {
+	case ActionCreate:
+		return fmt.Sprintf("%s %s is missing", kind, op.Name)
+	case ActionDelete:
+		return fmt.Sprintf("%s %s must be absent", kind, op.Name)
+	}
+	return fmt.Sprintf("%s %s differs: %s (spec: %s)", kind, op.Name, planValue(op.Current), planValue(op.Desired))
+}
+
+func printPlan() {
+	fmt.Printf("PLAN %s\n", strings.Repeat("*", 83))
+	if len(plan.Operations) == 0 {
+		fmt.Println(" No changes. The cluster is aligned with the spec")
+		return
+	}
+	for _, op := range plan.Operations {
+		var color string
+		switch op.Action {
+		case ActionCreate:
+			color = Ok
+		case ActionAlter:
+			color = Changed
+		case ActionDelete:
+			color = Error
+		}
+		fmt.Printf(color+" %s\n"+Default, op)
+	}
+	fmt.Printf(" to create=%d   to alter=%d   to delete=%d\n", plan.Count(ActionCreate), plan.Count(ActionAlter), plan.Count(ActionDelete))
+}
+
+func topicFromDetail(name string, detail sarama.TopicDetail) Topic {
+	topic := Topic{
+		Name:              name,
+		Partitions:        int(detail.NumPartitions),
+		ReplicationFactor: int(detail.ReplicationFactor),
+		Configs:           make(map[string]string),
+	}
+	for key, val := range detail.ConfigEntries {
+		topic.Configs[key] = *val
+	}
+	return topic
+}
+
+func singleACLFromSarama(resource sarama.Resource, acl *sarama.Acl) SingleACL {
+	return SingleACL{
+		PermissionType: aclPermissionTypeToString(acl.PermissionType),
+		Principal:      acl.Principal,
+		Resource: Resource{
+			Type:        aclResourceTypeToString(resource.ResourceType),
+			Pattern:     resource.ResourceName,
+			PatternType: aclResourcePatternTypeToString(resource.ResourcePatternType),
+		},
+		Operation: aclOperationToString(acl.Operation),
+		Host:      acl.Host,
+		State:     "present",
+	}
+}
+
+// aclFilterMatches mimics the broker-side matching of ACL filter used by DeleteACL
+func aclFilterMatches(filter sarama.AclFilter, resource sarama.Resource, acl *sarama.Acl) bool {
+	if filter.ResourceType != sarama.AclResourceAny && filter.ResourceType != resource.ResourceType {
+		return false
+	}
+	if filter.ResourceName != nil {
+		switch filter.ResourcePatternTypeFilter {
+		case sarama.AclPatternMatch:
+			switch resource.ResourcePatternType {
+			case sarama.AclPatternLiteral:
+				if resource.ResourceName != *filter.ResourceName && resource.ResourceName != "*" {
+					return false
+				}
+			case sarama.AclPatternPrefixed:
+				if !strings.HasPrefix(*filter.ResourceName, resource.ResourceName) {
+					return false
+				}
+			default:
+				return false
+			}
+		default:
+			if resource.ResourceName != *filter.ResourceName {
+				return false
+			}
+		}
+	}
+	if filter.ResourcePatternTypeFilter != sarama.AclPatternAny && filter.ResourcePatternTypeFilter != sarama.AclPatternMatch &&
+		filter.ResourcePatternTypeFilter != resource.ResourcePatternType {
+		return false
+	}
+	if filter.Principal != nil && *filter.Principal != acl.Principal {
+		return false
+	}
+	if filter.Host != nil && *filter.Host != acl.Host {
+		return false
+	}
+	if filter.Operation != sarama.AclOperationAny && filter.Operation != acl.Operation {
+		return false
+	}
+	if filter.PermissionType != sarama.AclPermissionAny && filter.PermissionType != acl.PermissionType {
+		return false
+	}
+	return true
+}
+
+func matchingAcls(acls *[]sarama.ResourceAcls, filter sarama.AclFilter) []SingleACL {
+	var matched []SingleACL
+	for _, resourceAcls := range *acls {
+		for _, currentAcl := range resourceAcls.Acls {
+			if aclFilterMatches(filter, resourceAcls.Resource, currentAcl) {
+				matched = append(matched, singleACLFromSarama(resourceAcls.Resource, currentAcl))
+			}
+		}
+	}
+	return matched
+}
+
+func (a SingleACL) String() string {
+	return fmt.Sprintf("%s %s@%s to %s %s:%s:%s", a.PermissionType, a.Principal, a.Host, a.Operation,
+		a.Resource.Type, a.Resource.PatternType, a.Resource.Pattern)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPlanSpecFile(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	// No handlers for the write requests: any admin write call would fail the plan
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"DescribeAclsRequest":    sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec.yaml"}
+	isTemplate = false
+	verbose = false
+	out, err := captureOutput(func() error { return planSpecFile() })
+
+	if err != nil {
+		t.Fatal("Failed to plan spec: " + err.Error())
+	}
+
+	expected := [5]string{
+		Ok + " ok=2   " + Default + Changed + " changed=8   " + Default + " failed=0\n" + Default,
+		"+ create topic my_topic1: (none) => {\"name\":\"my_topic1\",\"partitions\":3,\"replication_factor\":1",
+		"+ create acl ALLOW User:test1@* to READ topic:PREFIXED:my-",
+		"+ create acl DENY User:test1@* to DESCRIBE group:LITERAL:my-group",
+		" to create=8   to alter=0   to delete=0\n",
+	}
+
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+}
+
+func TestAclFilterMatches(t *testing.T) {
+	resource := sarama.Resource{
+		ResourceType:        sarama.AclResourceTopic,
+		ResourceName:        "my-",
+		ResourcePatternType: sarama.AclPatternPrefixed,
+	}
+	acl := &sarama.Acl{
+		Principal:      "User:test",
+		Host:           "*",
+		Operation:      sarama.AclOperationRead,
+		PermissionType: sarama.AclPermissionAllow,
+	}
+	name := "my-topic"
+	principal := "User:other"
+
+	var tests = []struct {
+		filter sarama.AclFilter
+		out    bool
+	}{
+		{sarama.AclFilter{ResourceType: sarama.AclResourceAny, ResourcePatternTypeFilter: sarama.AclPatternAny,
+			Operation: sarama.AclOperationAny, PermissionType: sarama.AclPermissionAny}, true},
+		{sarama.AclFilter{ResourceType: sarama.AclResourceTopic, ResourceName: &name, ResourcePatternTypeFilter: sarama.AclPatternMatch,
+			Operation: sarama.AclOperationRead, PermissionType: sarama.AclPermissionAllow}, true},
+		{sarama.AclFilter{ResourceType: sarama.AclResourceTopic, ResourceName: &name, ResourcePatternTypeFilter: sarama.AclPatternPrefixed,
+			Operation: sarama.AclOperationRead, PermissionType: sarama.AclPermissionAllow}, false},
+		{sarama.AclFilter{ResourceType: sarama.AclResourceGroup, ResourcePatternTypeFilter: sarama.AclPatternAny,
+			Operation: sarama.AclOperationAny, PermissionType: sarama.AclPermissionAny}, false},
+		{sarama.AclFilter{ResourceType: sarama.AclResourceAny, ResourcePatternTypeFilter: sarama.AclPatternAny, Principal: &principal,
+			Operation: sarama.AclOperationAny, PermissionType: sarama.AclPermissionAny}, false},
+	}
+
+	for i, tt := range tests {
+		val := aclFilterMatches(tt.filter, resource, acl)
+		if val != tt.out {
+			t.Errorf("aclFilterMatches failed for case %d, expected %v, got %v", i, tt.out, val)
+		}
+	}
+}
+
+func TestApplyPlanFile(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetController(seedBroker.BrokerID()).
+		SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+		SetLeader("my_topic", 0, seedBroker.BrokerID())
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":         metadata,
+		"DescribeAclsRequest":     sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest":  sarama.NewMockDescribeConfigsResponse(t),
+		"CreateAclsRequest":       sarama.NewMockCreateAclsResponse(t),
+		"CreateTopicsRequest":     sarama.NewMockCreateTopicsResponse(t),
+		"AlterConfigsRequest":     sarama.NewMockAlterConfigsResponse(t),
+		"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec.yaml"}
+	isTemplate = false
+	verbose = false
+	planFile = t.TempDir() + "/plan.json"
+	defer func() { planFile = "" }()
+
+	_, err := captureOutput(func() error { return planSpecFile() })
+	if err != nil {
+		t.Fatal("Failed to plan spec: " + err.Error())
+	}
+
+	savedPlan, err := readPlanFile(planFile)
+	if err != nil {
+		t.Fatal("Failed to read plan file: " + err.Error())
+	}
+	if len(savedPlan.Operations) != 8 || len(savedPlan.Spec.Topics) != 5 {
+		t.Fatalf("Unexpected plan content: %d operations, %d topics", len(savedPlan.Operations), len(savedPlan.Spec.Topics))
+	}
+
+	actionApply = true
+	defer func() { actionApply = false }()
+	specFiles = nil
+
+	// The plan made for another broker or with other operations is refused
+	original, _ := ioutil.ReadFile(planFile)
+	tampered := savedPlan
+	tampered.Broker = "localhost:19092"
+	writeTestPlan(t, tampered)
+	_, err = captureOutput(func() error { return applyPlanFile() })
+	if err == nil || !strings.Contains(err.Error(), "The plan was made for broker localhost:19092") {
+		t.Fatalf("Expected the plan for another broker to be refused, got %v", err)
+	}
+	tampered = savedPlan
+	tampered.Operations = append([]PlanOperation{}, savedPlan.Operations[1:]...)
+	writeTestPlan(t, tampered)
+	_, err = captureOutput(func() error { return applyPlanFile() })
+	if err == nil || !strings.Contains(err.Error(), "The operations differ from the saved plan") {
+		t.Fatalf("Expected the plan with other operations to be refused, got %v", err)
+	}
+	if err = ioutil.WriteFile(planFile, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := captureOutput(func() error { return applyPlanFile() })
+	if err != nil {
+		t.Fatal("Failed to apply plan: " + err.Error())
+	}
+	if !strings.Contains(out, "changed=8") || strings.Count(out, "SUMMARY") != 1 {
+		t.Fatalf("Output does not contain expected \"changed=8\" once:\n%s", out)
+	}
+
+	// The cluster has been changed after planning
+	metadata.SetLeader("my_topic5", 0, seedBroker.BrokerID())
+	_, err = captureOutput(func() error { return applyPlanFile() })
+	if err == nil || !strings.Contains(err.Error(), "The cluster has changed since the plan was made") {
+		t.Fatalf("Expected the plan to be refused, got %v", err)
+	}
+}
+
+func TestCheckSpecFile(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_alter_topic.yaml"}
+	isTemplate = false
+	verbose = false
+	var drift bool
+	out, err := captureOutput(func() (err error) {
+		drift, err = checkSpecFile()
+		return err
+	})
+
+	if err != nil {
+		t.Fatal("Failed to check spec: " + err.Error())
+	}
+	if !drift {
+		t.Fatalf("Expected the drift to be detected:\n%s", out)
+	}
+
+	expected := [4]string{
+		"topic my_topic partitions: 1 (spec: 2)",
+		"topic my_topic config cleanup.policy: not set (spec: compact)",
+		"topic my_topic config retention.ms: 5000 (spec: 1000)",
+		" drifted=3\n",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+
+	specFiles = arrFlags{"testdata/check_spec_in_sync.yaml"}
+	out, err = captureOutput(func() (err error) {
+		drift, err = checkSpecFile()
+		return err
+	})
+	if err != nil {
+		t.Fatal("Failed to check spec: " + err.Error())
+	}
+	if drift || !strings.Contains(out, "No drift") {
+		t.Fatalf("Expected no drift:\n%s", out)
+	}
+}
+
+func writeTestPlan(t *testing.T, p Plan) {
+	out, err := json.Marshal(p)
+	if err == nil {
+		err = ioutil.WriteFile(planFile, out, 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
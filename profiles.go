@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// TopicProfile contains the topic settings shared by several topics, either as the defaults or as a named profile
+type TopicProfile struct {
+	Partitions        int          `yaml:"partitions,omitempty" json:"partitions,omitempty"`
+	ReplicationFactor int          `yaml:"replication_factor,omitempty" json:"replication_factor,omitempty"`
+	Configs           TopicConfigs `yaml:"configs,omitempty" json:"configs,omitempty"`
+}
+
+// resolveProfiles expands the defaults and the profiles of the spec into the plain topics.
+// The fields of the topic take precedence over its profile, the profile takes precedence over the defaults
+func resolveProfiles(spec Spec) (Spec, error) {
+	var errs []string
+	for i, topic := range spec.Topics {
+		if topic.State == "absent" {
+			if topic.Profile != "" {
+				errs = append(errs, "Topic "+topic.Name+" with state=absent can't have a profile")
+			}
+			continue
+		}
+		var layers []TopicProfile
+		if spec.Defaults != nil {
+			layers = append(layers, *spec.Defaults)
+		}
+		if topic.Profile != "" {
+			profile, found := spec.Profiles[topic.Profile]
+			if !found {
+				errs = append(errs, "Profile "+topic.Profile+" of topic "+topic.Name+" is not defined, available: "+strings.Join(profileNames(spec), ", "))
+				continue
+			}
+			layers = append(layers, profile)
+		}
+		layers = append(layers, TopicProfile{Partitions: topic.Partitions, ReplicationFactor: topic.ReplicationFactor, Configs: topic.Configs})
+
+		var configs map[string]string
+		for _, layer := range layers {
+			if layer.Partitions > 0 {
+				topic.Partitions = layer.Partitions
+			}
+			if layer.ReplicationFactor > 0 {
+				topic.ReplicationFactor = layer.ReplicationFactor
+			}
+			for key, val := range layer.Configs {
+				if configs == nil {
+					configs = make(map[string]string)
+				}
+				configs[key] = val
+			}
+		}
+		if configs == nil && topic.Configs != nil {
+			configs = make(map[string]string)
+		}
+		topic.Configs = configs
+		topic.Profile = ""
+		spec.Topics[i] = topic
+	}
+	if len(errs) > 0 {
+		return spec, errors.New(strings.Join(errs, "\n"))
+	}
+	spec.Defaults = nil
+	spec.Profiles = nil
+	return spec, nil
+}
+
+func profileNames(spec Spec) []string {
+	var names []string
+	for name := range spec.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveProfiles(t *testing.T) {
+	specFiles = arrFlags{"testdata/apply_spec_profiles.yaml"}
+	isTemplate = false
+	defer func() { specFiles = nil }()
+
+	spec, err := parseSpecFile()
+	if err != nil {
+		t.Fatal("Failed to parse spec: " + err.Error())
+	}
+	expected := []Topic{
+		{Name: "user-state", Partitions: 1, ReplicationFactor: 2,
+			Configs: map[string]string{"min.insync.replicas": "1", "cleanup.policy": "compact", "segment.ms": "3600000"}},
+		{Name: "order-state", Partitions: 3, ReplicationFactor: 2,
+			Configs: map[string]string{"min.insync.replicas": "1", "cleanup.policy": "compact", "segment.ms": "600000"}},
+		{Name: "clicks", Partitions: 12, ReplicationFactor: 3,
+			Configs: map[string]string{"min.insync.replicas": "1", "retention.ms": "604800000"}},
+		{Name: "plain", Partitions: 1, ReplicationFactor: 2, Configs: map[string]string{"min.insync.replicas": "1"}},
+		{Name: "legacy", State: "absent"},
+	}
+	if !reflect.DeepEqual(spec.Topics, expected) {
+		t.Errorf("Profiles are resolved wrong:\n%+v\n%+v", spec.Topics, expected)
+	}
+	if spec.Defaults != nil || spec.Profiles != nil {
+		t.Errorf("Defaults and profiles must be removed from the resolved spec")
+	}
+
+	_, err = resolveProfiles(Spec{
+		Topics:   []Topic{{Name: "a", Profile: "missing"}, {Name: "b", Profile: "small", State: "absent"}},
+		Profiles: map[string]TopicProfile{"small": {Partitions: 1}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Profile missing of topic a is not defined, available: small") ||
+		!strings.Contains(err.Error(), "Topic b with state=absent can't have a profile") {
+		t.Errorf("resolveProfiles must report all the errors: %v", err)
+	}
+}
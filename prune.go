@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pruneIgnored checks whether the resource must never be pruned
+func pruneIgnored(name string) bool {
+	// Internal topics are never managed by the spec
+	if strings.HasPrefix(name, "__") {
+		return true
+	}
+	for _, pattern := range pruneIgnore {
+		if matched, _ := regexp.MatchString(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// inPruneScope checks whether the topic or consumer group can be pruned
+func inPruneScope(name string) bool {
+	if prunePrefix != "" && !strings.HasPrefix(name, prunePrefix) {
+		return false
+	}
+	if pruneMatch != "" {
+		if matched, _ := regexp.MatchString(pruneMatch, name); !matched {
+			return false
+		}
+	}
+	return !pruneIgnored(name)
+}
+
+// topicsToPrune returns the names of the topics which no spec entry covers
+func topicsToPrune(topics []Topic, currentTopics map[string]sarama.TopicDetail) []string {
+	var names []string
+	for name := range currentTopics {
+		if !inPruneScope(name) {
+			continue
+		}
+		covered := false
+		for _, topic := range topics {
+			// Topics deleted by pattern are handled by the spec itself
+			if topic.State == "absent" {
+				covered = patternMatches(name, topic.Name, topic.PatternType)
+			} else {
+				covered = name == topic.Name
+			}
+			if covered {
+				break
+			}
+		}
+		if !covered {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupsToPrune returns the names of the consumer groups which no spec entry covers
+func groupsToPrune(groups []ConsumerGroup, currentGroups map[string]string) []string {
+	var names []string
+	for name := range currentGroups {
+		if !inPruneScope(name) {
+			continue
+		}
+		covered := false
+		for _, group := range groups {
+			if patternMatches(name, group.Name, group.PatternType) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aclsToPrune returns the ACLs which no spec entry covers, marked as absent
+func aclsToPrune(sacls []SingleACL, currentAcls []sarama.ResourceAcls) []SingleACL {
+	var pruned []SingleACL
+	for _, resourceAcls := range currentAcls {
+		for _, currentAcl := range resourceAcls.Acls {
+			if len(prunePrincipals) > 0 && !containsString(prunePrincipals, currentAcl.Principal) {
+				continue
+			}
+			if pruneIgnored(resourceAcls.Resource.ResourceName) {
+				continue
+			}
+			single := []sarama.ResourceAcls{{Resource: resourceAcls.Resource, Acls: []*sarama.Acl{currentAcl}}}
+			covered := false
+			for _, sacl := range sacls {
+				sacl = normalizeAcl(sacl)
+				if sacl.State == "absent" {
+					covered = aclFilterMatches(aclFilter(sacl), resourceAcls.Resource, currentAcl)
+				} else {
+					covered = aclExists(nil, &single, sacl)
+				}
+				if covered {
+					break
+				}
+			}
+			if !covered {
+				sacl := singleACLFromSarama(resourceAcls.Resource, currentAcl)
+				sacl.State = "absent"
+				pruned = append(pruned, sacl)
+			}
+		}
+	}
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].String() < pruned[j].String() })
+	return pruned
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"strings"
+	"testing"
+)
+
+func TestPlanSpecFilePrune(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 2)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()).
+			SetLeader("my_old_topic", 0, seedBroker.BrokerID()).
+			SetLeader("ignored_topic", 0, seedBroker.BrokerID()).
+			SetLeader("__consumer_offsets", 0, seedBroker.BrokerID()),
+		"DescribeAclsRequest":    sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+			AddGroup("keep_me", "consumer").
+			AddGroup("old_group", "consumer"),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_prune.yaml"}
+	isTemplate = false
+	verbose = false
+	prune = true
+	pruneIgnore = arrFlags{"^ignored_"}
+	defer func() {
+		prune = false
+		pruneIgnore = nil
+		prunePrincipals = nil
+	}()
+	out, err := captureOutput(func() error { return planSpecFile() })
+
+	if err != nil {
+		t.Fatal("Failed to plan spec: " + err.Error())
+	}
+
+	expected := [5]string{
+		"TASK [TOPIC : Prune topic my_old_topic]",
+		"- delete topic my_old_topic",
+		"- delete consumer-group old_group",
+		"- delete acl ALLOW User:test@* to ANY any:ANY:",
+		" to create=0   to alter=0   to delete=3\n",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+	for _, str := range []string{"__consumer_offsets", "ignored_topic", "keep_me"} {
+		if strings.Contains(out, str) {
+			t.Fatalf("Output contains unexpected \"%s\":\n%s", str, out)
+		}
+	}
+
+	// ACLs of other principals are out of scope
+	prunePrincipals = arrFlags{"User:other"}
+	out, err = captureOutput(func() error { return planSpecFile() })
+	if err != nil {
+		t.Fatal("Failed to plan spec: " + err.Error())
+	}
+	if strings.Contains(out, "delete acl") {
+		t.Fatalf("Output contains unexpected ACL deletion:\n%s", out)
+	}
+}
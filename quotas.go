@@ -0,0 +1,194 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quota describes the client quotas of a user, a client-id or a user+client-id entity
+type Quota struct {
+	User                   string   `yaml:"user,omitempty" json:"user,omitempty"`
+	ClientID               string   `yaml:"client_id,omitempty" json:"client_id,omitempty"`
+	ProducerByteRate       *float64 `yaml:"producer_byte_rate,omitempty" json:"producer_byte_rate,omitempty"`
+	ConsumerByteRate       *float64 `yaml:"consumer_byte_rate,omitempty" json:"consumer_byte_rate,omitempty"`
+	RequestPercentage      *float64 `yaml:"request_percentage,omitempty" json:"request_percentage,omitempty"`
+	ControllerMutationRate *float64 `yaml:"controller_mutation_rate,omitempty" json:"controller_mutation_rate,omitempty"`
+	State                  string   `yaml:"state,omitempty" json:"state,omitempty"`
+}
+
+// quotaDefault is the name of the default entity (the quota applied to all users or client-ids without their own quota)
+const quotaDefault = "<default>"
+
+// Entity returns the name of the quota entity in the form user=alice,client-id=app
+func (q Quota) Entity() string {
+	var parts []string
+	if q.User != "" {
+		parts = append(parts, "user="+q.User)
+	}
+	if q.ClientID != "" {
+		parts = append(parts, "client-id="+q.ClientID)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Values returns the quotas defined for the entity
+func (q Quota) Values() map[string]float64 {
+	values := make(map[string]float64)
+	for key, val := range map[string]*float64{
+		"producer_byte_rate":       q.ProducerByteRate,
+		"consumer_byte_rate":       q.ConsumerByteRate,
+		"request_percentage":       q.RequestPercentage,
+		"controller_mutation_rate": q.ControllerMutationRate,
+	} {
+		if val != nil {
+			values[key] = *val
+		}
+	}
+	return values
+}
+
+func (q Quota) entityComponents() []sarama.QuotaEntityComponent {
+	var components []sarama.QuotaEntityComponent
+	for _, c := range []struct {
+		entityType sarama.QuotaEntityType
+		name       string
+	}{{sarama.QuotaEntityUser, q.User}, {sarama.QuotaEntityClientID, q.ClientID}} {
+		if c.name == "" {
+			continue
+		}
+		component := sarama.QuotaEntityComponent{EntityType: c.entityType, MatchType: sarama.QuotaMatchExact, Name: c.name}
+		if c.name == quotaDefault {
+			component = sarama.QuotaEntityComponent{EntityType: c.entityType, MatchType: sarama.QuotaMatchDefault}
+		}
+		components = append(components, component)
+	}
+	return components
+}
+
+func quotaFromSarama(entry sarama.DescribeClientQuotasEntry) Quota {
+	var quota Quota
+	for _, c := range entry.Entity {
+		name := c.Name
+		if c.MatchType == sarama.QuotaMatchDefault {
+			name = quotaDefault
+		}
+		switch c.EntityType {
+		case sarama.QuotaEntityUser:
+			quota.User = name
+		case sarama.QuotaEntityClientID:
+			quota.ClientID = name
+		}
+	}
+	for key, val := range entry.Values {
+		val := val
+		switch key {
+		case "producer_byte_rate":
+			quota.ProducerByteRate = &val
+		case "consumer_byte_rate":
+			quota.ConsumerByteRate = &val
+		case "request_percentage":
+			quota.RequestPercentage = &val
+		case "controller_mutation_rate":
+			quota.ControllerMutationRate = &val
+		}
+	}
+	return quota
+}
+
+// listAllQuotas returns the current quotas of the user and client-id entities sorted by the entity name
+func listAllQuotas(admin *sarama.ClusterAdmin) ([]Quota, error) {
+	entries, err := (*admin).DescribeClientQuotas(nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var quotas []Quota
+	for _, entry := range entries {
+		quota := quotaFromSarama(entry)
+		if quota.Entity() != "" {
+			quotas = append(quotas, quota)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Entity() < quotas[j].Entity() })
+	return quotas, nil
+}
+
+func alignQuota(admin *sarama.ClusterAdmin, currentQuotas []Quota, quota Quota) (string, error) {
+	var action string
+	if quota.State == "absent" {
+		action = "Remove"
+	} else {
+		action = "Set"
+	}
+	fmt.Printf("TASK [QUOTA : %s quota %s] %s\n", action, quota.Entity(), strings.Repeat("*", 52))
+
+	if quota.Entity() == "" {
+		return Error, errors.New("Quota entity not defined, please set user and/or client_id")
+	}
+	if quota.State != "" && quota.State != "present" && quota.State != "absent" {
+		return Error, errors.New("Quotas support only state=absent or state=present")
+	}
+
+	current := make(map[string]float64)
+	for _, q := range currentQuotas {
+		if q.Entity() == quota.Entity() {
+			current = q.Values()
+		}
+	}
+
+	var ops []sarama.ClientQuotasOp
+	if quota.State == "absent" {
+		for _, key := range sortedQuotaKeys(current) {
+			plan.Add(PlanOperation{Kind: "quota", Name: quota.Entity(), Key: key, Action: ActionDelete, Current: current[key]})
+			ops = append(ops, sarama.ClientQuotasOp{Key: key, Remove: true})
+		}
+	} else {
+		desired := quota.Values()
+		for _, key := range sortedQuotaKeys(desired) {
+			currentVal, found := current[key]
+			if !found {
+				plan.Add(PlanOperation{Kind: "quota", Name: quota.Entity(), Key: key, Action: ActionCreate, Desired: desired[key]})
+			} else if currentVal != desired[key] {
+				plan.Add(PlanOperation{Kind: "quota", Name: quota.Entity(), Key: key, Action: ActionAlter,
+					Current: currentVal, Desired: desired[key]})
+			} else {
+				continue
+			}
+			ops = append(ops, sarama.ClientQuotasOp{Key: key, Value: desired[key]})
+		}
+	}
+
+	if len(ops) == 0 {
+		return Ok, nil
+	}
+	if dryRun {
+		return Changed, nil
+	}
+	for _, op := range ops {
+		err := (*admin).AlterClientQuotas(quota.entityComponents(), op, false)
+		if err != nil {
+			return Error, quotaError(err)
+		}
+	}
+	return Changed, nil
+}
+
+func sortedQuotaKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// quotaError adds the hint about --kafka-version if the client refused to send the quota request
+func quotaError(err error) error {
+	if errors.Is(err, sarama.ErrUnsupportedVersion) {
+		return errors.New("Kafka 2.6.0 or newer is required for managing quotas, consider using --kafka-version option")
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"strings"
+	"testing"
+)
+
+func TestQuotaEntity(t *testing.T) {
+	var tests = []struct {
+		quota Quota
+		out   string
+	}{
+		{Quota{User: "alice"}, "user=alice"},
+		{Quota{ClientID: quotaDefault}, "client-id=<default>"},
+		{Quota{User: "alice", ClientID: "app"}, "user=alice,client-id=app"},
+	}
+
+	for _, tt := range tests {
+		val := tt.quota.Entity()
+		if val != tt.out {
+			t.Errorf("Entity failed, expected %s, got %s", tt.out, val)
+		}
+		quota := quotaFromSarama(sarama.DescribeClientQuotasEntry{Entity: tt.quota.entityComponents()})
+		if quota.Entity() != tt.out {
+			t.Errorf("quotaFromSarama failed, expected %s, got %s", tt.out, quota.Entity())
+		}
+	}
+}
+
+func TestApplySpecFileQuotas(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	producerByteRate := 1048576.0
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"ApiVersionsRequest":       sarama.NewMockApiVersionsResponse(t),
+		"DescribeConfigsRequest":   sarama.NewMockDescribeConfigsResponse(t),
+		"AlterClientQuotasRequest": sarama.NewMockWrapper(&sarama.AlterClientQuotasResponse{}),
+		"DescribeClientQuotasRequest": sarama.NewMockWrapper(&sarama.DescribeClientQuotasResponse{
+			Entries: []sarama.DescribeClientQuotasEntry{
+				{
+					Entity: Quota{User: "alice"}.entityComponents(),
+					Values: map[string]float64{"producer_byte_rate": producerByteRate, "consumer_byte_rate": 100},
+				},
+				{
+					Entity: Quota{User: "carol"}.entityComponents(),
+					Values: map[string]float64{"consumer_byte_rate": 100},
+				},
+			},
+		}),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_quotas.yaml"}
+	isTemplate = false
+	verbose = false
+	kafkaVersion = "2.6.0"
+	defer func() { kafkaVersion = "2.2.0" }()
+	out, err := captureOutput(func() error { return planSpecFile() })
+
+	if err != nil {
+		t.Fatalf("Failed to plan spec: %s\n%s", err, out)
+	}
+
+	expected := [6]string{
+		"[QUOTA : Set quota user=alice]",
+		"~ alter quota user=alice consumer_byte_rate: 100 => 2097152",
+		"+ create quota user=bob,client-id=<default> request_percentage: (none) => 200",
+		"+ create quota client-id=<default> controller_mutation_rate: (none) => 10",
+		"- delete quota user=carol consumer_byte_rate: 100 => (none)",
+		" to create=2   to alter=1   to delete=1\n",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+
+	out, err = captureOutput(func() error { return applySpecFile() })
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "changed=4") {
+		t.Fatalf("Output does not contain expected \"changed=4\":\n%s", out)
+	}
+}
+
+func TestDumpSpecQuotasUnauthorized(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"ApiVersionsRequest":     sarama.NewMockApiVersionsResponse(t),
+		"DescribeAclsRequest":    sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"DescribeClientQuotasRequest": sarama.NewMockWrapper(&sarama.DescribeClientQuotasResponse{
+			ErrorCode: sarama.ErrClusterAuthorizationFailed,
+		}),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	kafkaVersion = "2.6.0"
+	defer func() { kafkaVersion = "2.2.0" }()
+
+	out, err := captureOutput(func() error { return dumpSpec() })
+	if err != nil {
+		t.Fatalf("Dump must skip the quotas the principal is not allowed to describe: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "Warning: quotas are not dumped") || !strings.Contains(out, "name: my_topic") {
+		t.Fatalf("Output does not contain the warning and the topics:\n%s", out)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// reassignPollInterval defines how often the progress of the partition reassignment is checked
+var reassignPollInterval = 5 * time.Second
+
+// buildReassignment calculates the new replica assignment for the desired replication factor.
+// The existing replicas are kept in their order (so the preferred leaders do not move),
+// the extra replicas are removed from the tail and the new ones are added to the least loaded brokers.
+// If racks are defined then the brokers from the racks not yet used by the partition are preferred
+func buildReassignment(current map[int32][]int32, racks map[int32]string, replicationFactor int, rackAware bool) ([][]int32, error) {
+	if replicationFactor > len(racks) {
+		return nil, fmt.Errorf("Replication-factor %d is larger than the number of brokers %d", replicationFactor, len(racks))
+	}
+	partitions := make([]int32, 0, len(current))
+	for partition := range current {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	load := make(map[int32]int)
+	for id := range racks {
+		load[id] = 0
+	}
+	assignment := make([][]int32, len(partitions))
+	for i, partition := range partitions {
+		if int(partition) != i {
+			return nil, fmt.Errorf("Partition %d is missing in the current assignment", i)
+		}
+		replicas := current[partition]
+		if len(replicas) > replicationFactor {
+			replicas = replicas[:replicationFactor]
+		}
+		assignment[i] = append([]int32{}, replicas...)
+		for _, id := range assignment[i] {
+			load[id]++
+		}
+	}
+
+	ids := sortedBrokerIDs(racks)
+	for i := range assignment {
+		for len(assignment[i]) < replicationFactor {
+			usedRacks := make(map[string]bool)
+			if rackAware {
+				for _, id := range assignment[i] {
+					usedRacks[racks[id]] = true
+				}
+			}
+			// Walk the brokers ring starting after the last replica, so the ties are spread evenly
+			start := 0
+			if len(assignment[i]) > 0 {
+				last := assignment[i][len(assignment[i])-1]
+				for j, id := range ids {
+					if id == last {
+						start = j + 1
+					}
+				}
+			}
+			candidate := int32(-1)
+			for j := range ids {
+				id := ids[(start+j)%len(ids)]
+				if containsInt32(assignment[i], id) {
+					continue
+				}
+				if candidate < 0 || (usedRacks[racks[candidate]] && !usedRacks[racks[id]]) ||
+					(usedRacks[racks[candidate]] == usedRacks[racks[id]] && load[id] < load[candidate]) {
+					candidate = id
+				}
+			}
+			assignment[i] = append(assignment[i], candidate)
+			load[candidate]++
+		}
+	}
+	return assignment, nil
+}
+
+// rackAwareAssignment calculates the replica assignment for the partitions first..first+count-1
+// so the replicas of each partition are placed in different racks (as long as there are enough racks)
+func rackAwareAssignment(racks map[int32]string, first int, count int, replicationFactor int) ([][]int32, error) {
+	if replicationFactor > len(racks) {
+		return nil, fmt.Errorf("Replication-factor %d is larger than the number of brokers %d", replicationFactor, len(racks))
+	}
+	// Interleave the brokers of different racks: rack1-broker1, rack2-broker1, rack1-broker2, ...
+	byRack := make(map[string][]int32)
+	var rackNames []string
+	for _, id := range sortedBrokerIDs(racks) {
+		rack := racks[id]
+		if rack == "" {
+			return nil, fmt.Errorf("Broker %d has no rack defined, rack-aware assignment is not possible", id)
+		}
+		if _, found := byRack[rack]; !found {
+			rackNames = append(rackNames, rack)
+		}
+		byRack[rack] = append(byRack[rack], id)
+	}
+	sort.Strings(rackNames)
+	var ids []int32
+	for i := 0; len(ids) < len(racks); i++ {
+		for _, rack := range rackNames {
+			if i < len(byRack[rack]) {
+				ids = append(ids, byRack[rack][i])
+			}
+		}
+	}
+
+	assignment := make([][]int32, count)
+	for p := first; p < first+count; p++ {
+		var replicas []int32
+		usedRacks := make(map[string]bool)
+		for j := 0; j < len(ids) && len(replicas) < replicationFactor; j++ {
+			id := ids[(p+j)%len(ids)]
+			if !usedRacks[racks[id]] {
+				replicas = append(replicas, id)
+				usedRacks[racks[id]] = true
+			}
+		}
+		for j := 0; len(replicas) < replicationFactor; j++ {
+			id := ids[(p+j)%len(ids)]
+			if !containsInt32(replicas, id) {
+				replicas = append(replicas, id)
+			}
+		}
+		assignment[p-first] = replicas
+	}
+	return assignment, nil
+}
+
+// topicAssignment returns the replica assignment for the partitions first..first+count-1 of the topic:
+// either the explicit one from the spec or the rack-aware one. It returns nil if Kafka should decide itself
+func topicAssignment(topic Topic, first int, count int, brokers []*sarama.Broker) ([][]int32, error) {
+	if len(topic.ReplicaAssignment) > 0 {
+		assignment := make([][]int32, count)
+		for p := first; p < first+count; p++ {
+			replicas, found := topic.ReplicaAssignment[int32(p)]
+			if !found {
+				return nil, fmt.Errorf("Partition %d is missing in replica_assignment", p)
+			}
+			assignment[p-first] = replicas
+		}
+		return assignment, nil
+	}
+	if topic.RackAware {
+		return rackAwareAssignment(brokerRacks(brokers), first, count, topic.ReplicationFactor)
+	}
+	return nil, nil
+}
+
+// normalizeReplicaAssignment checks the explicit replica assignment of the topic
+// and derives the number of partitions and the replication-factor from it
+func normalizeReplicaAssignment(topic Topic, brokers []*sarama.Broker) (Topic, error) {
+	if len(topic.ReplicaAssignment) == 0 {
+		return topic, nil
+	}
+	if topic.RackAware {
+		return topic, errors.New("Options replica_assignment and rack_aware cannot be used together")
+	}
+	racks := brokerRacks(brokers)
+	replicationFactor := -1
+	for p := 0; p < len(topic.ReplicaAssignment); p++ {
+		replicas, found := topic.ReplicaAssignment[int32(p)]
+		if !found {
+			return topic, fmt.Errorf("Partition %d is missing in replica_assignment", p)
+		}
+		if replicationFactor < 0 {
+			replicationFactor = len(replicas)
+		} else if replicationFactor != len(replicas) {
+			return topic, fmt.Errorf("All partitions in replica_assignment must have the same number of replicas")
+		}
+		for i, id := range replicas {
+			if _, found := racks[id]; !found {
+				return topic, fmt.Errorf("Broker %d from replica_assignment does not exist", id)
+			}
+			if containsInt32(replicas[:i], id) {
+				return topic, fmt.Errorf("Broker %d is duplicated in replica_assignment of partition %d", id, p)
+			}
+		}
+	}
+	if topic.Partitions == 0 {
+		topic.Partitions = len(topic.ReplicaAssignment)
+	} else if topic.Partitions != len(topic.ReplicaAssignment) {
+		return topic, fmt.Errorf("Number of partitions %d does not match replica_assignment", topic.Partitions)
+	}
+	if topic.ReplicationFactor == 0 {
+		topic.ReplicationFactor = replicationFactor
+	} else if topic.ReplicationFactor != replicationFactor {
+		return topic, fmt.Errorf("Replication-factor %d does not match replica_assignment", topic.ReplicationFactor)
+	}
+	return topic, nil
+}
+
+// assignmentDiffers checks whether the existing partitions are assigned differently from the spec
+func assignmentDiffers(current map[int32][]int32, desired map[int32][]int32) bool {
+	for partition, replicas := range current {
+		desiredReplicas, found := desired[partition]
+		if !found || len(desiredReplicas) != len(replicas) {
+			return true
+		}
+		for i := range replicas {
+			if replicas[i] != desiredReplicas[i] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func alterReplicationFactor(topic Topic, clusterAdmin *sarama.ClusterAdmin, current map[int32][]int32, brokers []*sarama.Broker) error {
+	assignment, err := buildReassignment(current, brokerRacks(brokers), topic.ReplicationFactor, topic.RackAware)
+	if err != nil {
+		return err
+	}
+	return reassignPartitions(topic.Name, clusterAdmin, assignment)
+}
+
+func alterReplicaAssignment(topic Topic, clusterAdmin *sarama.ClusterAdmin, numPartitions int) error {
+	assignment, err := topicAssignment(topic, 0, numPartitions, nil)
+	if err != nil {
+		return err
+	}
+	return reassignPartitions(topic.Name, clusterAdmin, assignment)
+}
+
+func reassignPartitions(topic string, clusterAdmin *sarama.ClusterAdmin, assignment [][]int32) error {
+	if dryRun {
+		return nil
+	}
+
+	admin := *clusterAdmin
+	fmt.Printf("Reassigning %d partitions of topic %s to replicas %v\n", len(assignment), topic, assignment)
+	err := admin.AlterPartitionReassignments(topic, assignment)
+	if errors.Is(err, sarama.ErrUnsupportedVersion) {
+		return errors.New("Can't reassign partitions: Kafka 2.4.0 or newer is required, consider using --kafka-version option")
+	}
+	if err != nil {
+		return errors.New("Can't reassign partitions: " + err.Error())
+	}
+	return waitForReassignment(topic, clusterAdmin, len(assignment))
+}
+
+func waitForReassignment(topic string, clusterAdmin *sarama.ClusterAdmin, numPartitions int) error {
+	admin := *clusterAdmin
+	partitions := make([]int32, numPartitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	start := time.Now()
+	for {
+		status, err := admin.ListPartitionReassignments(topic, partitions)
+		if err != nil {
+			return errors.New("Can't get the reassignment status: " + err.Error())
+		}
+		remaining := len(status[topic])
+		if remaining == 0 {
+			fmt.Printf("Reassignment of topic %s completed in %s\n", topic, time.Since(start).Round(time.Second))
+			return nil
+		}
+		if time.Since(start) > reassignTimeout {
+			return fmt.Errorf("Reassignment of topic %s is not completed in %s, %d partitions are still being reassigned", topic, reassignTimeout, remaining)
+		}
+		fmt.Printf("Reassignment of topic %s is in progress: %d of %d partitions remaining\n", topic, remaining, numPartitions)
+		time.Sleep(reassignPollInterval)
+	}
+}
+
+func brokerRacks(brokers []*sarama.Broker) map[int32]string {
+	racks := make(map[int32]string)
+	for _, b := range brokers {
+		racks[b.ID()] = b.Rack()
+	}
+	return racks
+}
+
+func sortedBrokerIDs(racks map[int32]string) []int32 {
+	ids := make([]int32, 0, len(racks))
+	for id := range racks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func containsInt32(list []int32, n int32) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
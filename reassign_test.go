@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var noRacks = map[int32]string{1: "", 2: "", 3: ""}
+var threeRacks = map[int32]string{1: "az1", 2: "az1", 3: "az2", 4: "az2", 5: "az3", 6: "az3"}
+
+var buildReassignmentTests = []struct {
+	current           map[int32][]int32
+	racks             map[int32]string
+	replicationFactor int
+	rackAware         bool
+	out               [][]int32
+}{
+	{map[int32][]int32{0: {1}, 1: {2}, 2: {3}}, noRacks, 2, false, [][]int32{{1, 2}, {2, 3}, {3, 1}}},
+	{map[int32][]int32{0: {1, 2, 3}, 1: {2, 3, 1}}, noRacks, 2, false, [][]int32{{1, 2}, {2, 3}}},
+	{map[int32][]int32{0: {1}, 1: {1}}, noRacks, 3, false, [][]int32{{1, 2, 3}, {1, 2, 3}}},
+	{map[int32][]int32{0: {2}, 1: {3}}, map[int32]string{1: "", 2: "", 3: "", 4: ""}, 2, false, [][]int32{{2, 4}, {3, 1}}},
+	{map[int32][]int32{0: {1}, 1: {3}}, threeRacks, 3, true, [][]int32{{1, 4, 5}, {3, 6, 2}}},
+}
+
+func TestBuildReassignment(t *testing.T) {
+	for _, tt := range buildReassignmentTests {
+		val, err := buildReassignment(tt.current, tt.racks, tt.replicationFactor, tt.rackAware)
+		if err != nil {
+			t.Errorf("buildReassignment failed: %s", err)
+		} else if !reflect.DeepEqual(val, tt.out) {
+			t.Errorf("buildReassignment failed, expected %v, got %v", tt.out, val)
+		}
+	}
+
+	_, err := buildReassignment(map[int32][]int32{0: {1}}, map[int32]string{1: ""}, 2, false)
+	if err == nil {
+		t.Errorf("buildReassignment must fail when replication-factor is larger than the number of brokers")
+	}
+}
+
+func TestRackAwareAssignment(t *testing.T) {
+	val, err := rackAwareAssignment(threeRacks, 0, 4, 3)
+	if err != nil {
+		t.Fatalf("rackAwareAssignment failed: %s", err)
+	}
+	expected := [][]int32{{1, 3, 5}, {3, 5, 2}, {5, 2, 4}, {2, 4, 6}}
+	if !reflect.DeepEqual(val, expected) {
+		t.Errorf("rackAwareAssignment failed, expected %v, got %v", expected, val)
+	}
+
+	// New partitions continue the sequence
+	val, _ = rackAwareAssignment(threeRacks, 2, 1, 3)
+	if !reflect.DeepEqual(val, expected[2:3]) {
+		t.Errorf("rackAwareAssignment failed, expected %v, got %v", expected[2:3], val)
+	}
+
+	_, err = rackAwareAssignment(noRacks, 0, 1, 2)
+	if err == nil {
+		t.Errorf("rackAwareAssignment must fail when brokers have no racks")
+	}
+}
+
+func TestNormalizeReplicaAssignment(t *testing.T) {
+	brokers := []*sarama.Broker{sarama.NewBroker("b1:9092"), sarama.NewBroker("b2:9092")}
+	topic := Topic{Name: "my_topic", ReplicaAssignment: map[int32][]int32{0: {-1}, 1: {-1}}}
+	topic, err := normalizeReplicaAssignment(topic, brokers)
+	if err != nil || topic.Partitions != 2 || topic.ReplicationFactor != 1 {
+		t.Errorf("normalizeReplicaAssignment failed: %v %v", topic, err)
+	}
+
+	topic = Topic{Name: "my_topic", ReplicaAssignment: map[int32][]int32{0: {-1}, 2: {-1}}}
+	_, err = normalizeReplicaAssignment(topic, brokers)
+	if err == nil {
+		t.Errorf("normalizeReplicaAssignment must fail when a partition is missing")
+	}
+
+	topic = Topic{Name: "my_topic", ReplicaAssignment: map[int32][]int32{0: {5}}}
+	_, err = normalizeReplicaAssignment(topic, brokers)
+	if err == nil {
+		t.Errorf("normalizeReplicaAssignment must fail when a broker does not exist")
+	}
+}
+
+func TestApplySpecFileReplicationFactor(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	broker2 := sarama.NewMockBroker(t, 2)
+	defer broker2.Close()
+	broker3 := sarama.NewMockBroker(t, 3)
+	defer broker3.Close()
+
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetBroker(broker2.Addr(), broker2.BrokerID()).
+			SetBroker(broker3.Addr(), broker3.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"ApiVersionsRequest":                 sarama.NewMockApiVersionsResponse(t),
+		"DescribeConfigsRequest":             sarama.NewMockDescribeConfigsResponse(t),
+		"AlterPartitionReassignmentsRequest": sarama.NewMockAlterPartitionReassignmentsResponse(t),
+		"ListPartitionReassignmentsRequest":  sarama.NewMockWrapper(&sarama.ListPartitionReassignmentsResponse{}),
+	}
+	for _, b := range []*sarama.MockBroker{seedBroker, broker2, broker3} {
+		b.SetHandlerByMap(handlers)
+	}
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_replication_factor.yaml"}
+	isTemplate = false
+	verbose = false
+	defer func() { kafkaVersion = "2.2.0" }()
+
+	// The reassignment is not planned for the cluster which can't do it
+	kafkaVersion = "2.3.0"
+	out, err := captureOutput(func() error { return planSpecFile() })
+	if err == nil || !strings.Contains(out, "Kafka 2.4.0 or newer is required for changing the replication-factor") || len(plan.Operations) > 0 {
+		t.Fatalf("Plan must fail for Kafka 2.3.0: %v\n%s", err, out)
+	}
+
+	kafkaVersion = "2.4.0"
+	out, err = captureOutput(func() error { return applySpecFile() })
+
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+
+	expected := [3]string{
+		"[TOPIC : Modify topic my_topic (partitions=1, replicas=2)]",
+		"Reassignment of topic my_topic completed",
+		"changed=1",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+}
+
+func TestApplySpecFileReplicaAssignment(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"DescribeConfigsRequest":  sarama.NewMockDescribeConfigsResponse(t),
+		"CreateTopicsRequest":     sarama.NewMockCreateTopicsResponse(t),
+		"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_replica_assignment.yaml"}
+	isTemplate = false
+	verbose = false
+	out, err := captureOutput(func() error { return applySpecFile() })
+
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+
+	expected := [3]string{
+		"[TOPIC : Create topic my_new_topic (partitions=2, replicas=1)]",
+		"[TOPIC : Modify topic my_topic (partitions=2, replicas=1)]",
+		"changed=2",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+}
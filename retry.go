@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// retriableErrors are the Kafka errors which usually go away by themselves, e.g. during the rolling restart of the brokers
+var retriableErrors = []sarama.KError{
+	sarama.ErrNotController,
+	sarama.ErrRequestTimedOut,
+	sarama.ErrLeaderNotAvailable,
+	sarama.ErrNotLeaderForPartition,
+	sarama.ErrBrokerNotAvailable,
+	sarama.ErrNetworkException,
+	sarama.ErrKafkaStorageError,
+	sarama.ErrNotEnoughReplicas,
+	sarama.ErrNotEnoughReplicasAfterAppend,
+	sarama.ErrThrottlingQuotaExceeded,
+}
+
+// isRetriable reports whether the failed admin call can succeed when repeated
+func isRetriable(err error) bool {
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		for _, e := range retriableErrors {
+			if kerr == e {
+				return true
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, sarama.ErrOutOfBrokers) ||
+		errors.Is(err, sarama.ErrNotConnected) ||
+		errors.Is(err, sarama.ErrControllerNotAvailable)
+}
+
+// withRetry calls the admin operation until it succeeds, fails with the non-retriable error or the retries are exhausted.
+// The delay between the attempts grows exponentially from --retry-backoff up to --retry-backoff-max.
+// Every retry is reported in the output of the current task
+func withRetry(operation string, f func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt > retries || !isRetriable(err) {
+			return err
+		}
+		fmt.Printf(Changed+"retry: [%s] %s failed: %s (attempt %d of %d in %s)\n"+Default,
+			broker, operation, err, attempt+1, retries+1, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+}
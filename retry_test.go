@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsRetriable(t *testing.T) {
+	var tests = []struct {
+		err       error
+		retriable bool
+	}{
+		{sarama.ErrNotController, true},
+		{&sarama.TopicError{Err: sarama.ErrRequestTimedOut}, true},
+		{sarama.ErrOutOfBrokers, true},
+		{sarama.ErrTopicAlreadyExists, false},
+		{&sarama.TopicPartitionError{Err: sarama.ErrInvalidPartitions}, false},
+		{errors.New("Unknown error"), false},
+	}
+	for _, tt := range tests {
+		if isRetriable(tt.err) != tt.retriable {
+			t.Errorf("isRetriable(%v) must be %v", tt.err, tt.retriable)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	retries, retryBackoff, retryBackoffMax = 2, time.Millisecond, 2*time.Millisecond
+	defer func() { retries, retryBackoff, retryBackoffMax = 0, 0, 0 }()
+
+	attempts := 0
+	out, err := captureOutput(func() error {
+		return withRetry("CreateTopic my_topic", func() error {
+			attempts++
+			if attempts < 3 {
+				return sarama.ErrNotController
+			}
+			return nil
+		})
+	})
+	if err != nil || attempts != 3 || strings.Count(out, "retry: ") != 2 || !strings.Contains(out, "CreateTopic my_topic failed") {
+		t.Errorf("withRetry must succeed on the third attempt: %v %d\n%s", err, attempts, out)
+	}
+
+	attempts = 0
+	_, err = captureOutput(func() error {
+		return withRetry("CreateTopic my_topic", func() error { attempts++; return sarama.ErrNotController })
+	})
+	if !errors.Is(err, sarama.ErrNotController) || attempts != 3 {
+		t.Errorf("withRetry must give up after the retries: %v %d", err, attempts)
+	}
+
+	attempts = 0
+	_, err = captureOutput(func() error {
+		return withRetry("CreateTopic my_topic", func() error { attempts++; return sarama.ErrTopicAlreadyExists })
+	})
+	if !errors.Is(err, sarama.ErrTopicAlreadyExists) || attempts != 1 {
+		t.Errorf("withRetry must not retry the non-retriable error: %v %d", err, attempts)
+	}
+}
+
+func TestApplySpecFileRetry(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("my_topic", 0, seedBroker.BrokerID()),
+		"DescribeAclsRequest":    sarama.NewMockListAclsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"CreateAclsRequest":      sarama.NewMockCreateAclsResponse(t),
+		"CreateTopicsRequest": sarama.NewMockSequence(
+			sarama.NewMockWrapper(&sarama.CreateTopicsResponse{
+				Version:     3,
+				TopicErrors: map[string]*sarama.TopicError{"my_topic1": {Err: sarama.ErrRequestTimedOut}},
+			}),
+			sarama.NewMockWrapper(&sarama.CreateTopicsResponse{
+				Version:     3,
+				TopicErrors: map[string]*sarama.TopicError{"my_topic1": {Err: sarama.ErrTopicAlreadyExists}},
+			}),
+			sarama.NewMockCreateTopicsResponse(t),
+		),
+		"AlterConfigsRequest":     sarama.NewMockAlterConfigsResponse(t),
+		"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec.yaml"}
+	isTemplate = false
+	retries, retryBackoff, retryBackoffMax = 2, time.Millisecond, time.Millisecond
+	defer func() { retries, retryBackoff, retryBackoffMax = 0, 0, 0 }()
+
+	out, err := captureOutput(func() error { return applySpecFile() })
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+	// The timed out creation has created the topic, so it exists on the retry
+	expected := "[TOPIC : Create topic my_topic1 (partitions=3, replicas=1)] " + strings.Repeat("*", 25) + "\n" +
+		Changed + "retry: [" + broker + "] CreateTopic my_topic1 failed: "
+	if !strings.Contains(out, expected) || !strings.Contains(out, " failed=0\n") {
+		t.Fatalf("Output does not contain the retry of the task:\n%s", out)
+	}
+}
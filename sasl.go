@@ -0,0 +1,133 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configureSASL sets up the SASL authentication with the selected mechanism
+func configureSASL(config *sarama.Config) error {
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = username
+	config.Net.SASL.Password = password
+	switch mechanism {
+	case "scram-sha-256":
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA256} }
+	case "scram-sha-512":
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA512} }
+	case "plain":
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case "gssapi":
+		config.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+		config.Net.SASL.GSSAPI = sarama.GSSAPIConfig{
+			AuthType:           sarama.KRB5_USER_AUTH,
+			KerberosConfigPath: kerberosConfig,
+			ServiceName:        kerberosServiceName,
+			Username:           username,
+			Password:           password,
+			Realm:              kerberosRealm,
+		}
+		if kerberosKeytab != "" {
+			config.Net.SASL.GSSAPI.AuthType = sarama.KRB5_KEYTAB_AUTH
+			config.Net.SASL.GSSAPI.KeyTabPath = kerberosKeytab
+		} else if kerberosCcache != "" {
+			config.Net.SASL.GSSAPI.AuthType = sarama.KRB5_CCACHE_AUTH
+			config.Net.SASL.GSSAPI.CCachePath = kerberosCcache
+		}
+	case "oauthbearer":
+		if oauthTokenURL == "" {
+			return errors.New("OAuth token endpoint must be defined for oauthbearer mechanism")
+		}
+		config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+		config.Net.SASL.TokenProvider = newClientCredentialsTokenProvider(oauthTokenURL, oauthClientID, oauthClientSecret, oauthScopes)
+	default:
+		return errors.New("The only supported SASL mechanisms: scram-sha-256, scram-sha-512, plain, gssapi, oauthbearer")
+	}
+	return nil
+}
+
+// clientCredentialsTokenProvider gets the OAuth access tokens with client credentials grant.
+// The token is cached and a new one is requested when 80% of its lifetime has passed
+type clientCredentialsTokenProvider struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+func newClientCredentialsTokenProvider(tokenURL string, clientID string, clientSecret string, scopes []string) *clientCredentialsTokenProvider {
+	return &clientCredentialsTokenProvider{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token returns the access token for SASL/OAUTHBEARER authentication
+func (p *clientCredentialsTokenProvider) Token() (*sarama.AccessToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" || !time.Now().Before(p.refreshAt) {
+		err := p.refresh()
+		if err != nil {
+			return nil, errors.New("Can't get OAuth token: " + err.Error())
+		}
+	}
+	return &sarama.AccessToken{Token: p.token}, nil
+}
+
+func (p *clientCredentialsTokenProvider) refresh() error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(p.scopes) > 0 {
+		form.Set("scope", strings.Join(p.scopes, " "))
+	}
+	req, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return err
+	}
+	if body.AccessToken == "" {
+		return errors.New("token endpoint returned no access_token")
+	}
+	p.token = body.AccessToken
+	p.refreshAt = start.Add(time.Duration(body.ExpiresIn) * time.Second * 8 / 10)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigureSASL(t *testing.T) {
+	defer func() {
+		mechanism = "scram-sha-256"
+		kerberosKeytab, oauthTokenURL = "", ""
+	}()
+
+	var tests = []struct {
+		mechanism string
+		out       sarama.SASLMechanism
+	}{
+		{"scram-sha-256", sarama.SASLTypeSCRAMSHA256},
+		{"scram-sha-512", sarama.SASLTypeSCRAMSHA512},
+		{"plain", sarama.SASLTypePlaintext},
+		{"gssapi", sarama.SASLTypeGSSAPI},
+	}
+	for _, tt := range tests {
+		mechanism = tt.mechanism
+		config := sarama.NewConfig()
+		err := configureSASL(config)
+		if err != nil || config.Net.SASL.Mechanism != tt.out {
+			t.Errorf("configureSASL failed for %s: %v %v", tt.mechanism, config.Net.SASL.Mechanism, err)
+		}
+	}
+
+	mechanism = "gssapi"
+	kerberosKeytab = "/etc/kafka.keytab"
+	config := sarama.NewConfig()
+	_ = configureSASL(config)
+	if config.Net.SASL.GSSAPI.AuthType != sarama.KRB5_KEYTAB_AUTH || config.Net.SASL.GSSAPI.KeyTabPath != kerberosKeytab {
+		t.Errorf("configureSASL failed for keytab: %+v", config.Net.SASL.GSSAPI)
+	}
+
+	mechanism = "oauthbearer"
+	if err := configureSASL(sarama.NewConfig()); err == nil {
+		t.Errorf("configureSASL must fail without OAuth token endpoint")
+	}
+	oauthTokenURL = "https://auth.example.local/token"
+	config = sarama.NewConfig()
+	if err := configureSASL(config); err != nil || config.Net.SASL.TokenProvider == nil {
+		t.Errorf("configureSASL failed for oauthbearer: %v", err)
+	}
+
+	mechanism = "digest-md5"
+	if err := configureSASL(sarama.NewConfig()); err == nil {
+		t.Errorf("configureSASL must fail for unsupported mechanism")
+	}
+}
+
+func TestClientCredentialsTokenProvider(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, secret, _ := r.BasicAuth()
+		if user != "kafka-ops" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" ||
+			r.FormValue("scope") != "kafka admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests++
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, requests)
+	}))
+	defer server.Close()
+
+	provider := newClientCredentialsTokenProvider(server.URL, "kafka-ops", "secret", []string{"kafka", "admin"})
+	for i := 0; i < 2; i++ {
+		token, err := provider.Token()
+		if err != nil || token.Token != "token-1" {
+			t.Fatalf("Token failed: %v %v", token, err)
+		}
+	}
+
+	// The token is about to expire
+	provider.refreshAt = time.Now()
+	token, err := provider.Token()
+	if err != nil || token.Token != "token-2" {
+		t.Fatalf("Token is not refreshed: %v %v", token, err)
+	}
+
+	provider = newClientCredentialsTokenProvider(server.URL, "kafka-ops", "wrong", []string{"kafka", "admin"})
+	if _, err = provider.Token(); err == nil {
+		t.Fatalf("Token must fail with wrong credentials")
+	}
+}
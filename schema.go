@@ -0,0 +1,128 @@
+package main
+
+//go:generate sh -c "go run . --schema > spec.schema.json"
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// specSchema generates the JSON Schema of the spec from the Go types and the validation rules,
+// so the schema published in spec.schema.json can't drift from the code
+func specSchema() map[string]interface{} {
+	definitions := make(map[string]interface{})
+	schema := structSchema(reflect.TypeOf(Spec{}), definitions)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "kafka-ops spec"
+	schema["definitions"] = definitions
+	return schema
+}
+
+// typeSchema returns the schema of the type, the structs are added to the definitions and referenced by name
+func typeSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, found := definitions[t.Name()]; !found {
+			definitions[t.Name()] = nil
+			definitions[t.Name()] = structSchema(t, definitions)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Map:
+		elem := typeSchema(t.Elem(), definitions)
+		if t == reflect.TypeOf(TopicConfigs{}) {
+			// The config values are converted to strings, e.g. retention.ms: 86400000
+			elem = map[string]interface{}{"type": []string{"string", "number", "boolean"}}
+		}
+		schema := map[string]interface{}{"type": "object", "additionalProperties": elem}
+		if t.Key().Kind() != reflect.String {
+			schema["propertyNames"] = map[string]interface{}{"pattern": "^[0-9]+$"}
+		}
+		return schema
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), definitions)}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func structSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	rules := specRules[t]
+	properties := make(map[string]interface{})
+	for name, field := range yamlFields(t) {
+		property := typeSchema(field.Type, definitions)
+		if rule, found := rules.fields[name]; found {
+			applyRule(property, rule)
+		}
+		properties[name] = property
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(rules.required) > 0 {
+		schema["required"] = rules.required
+	}
+	if len(rules.anyOf) > 0 {
+		var anyOf []interface{}
+		for _, name := range rules.anyOf {
+			anyOf = append(anyOf, map[string]interface{}{"required": []string{name}})
+		}
+		schema["anyOf"] = anyOf
+	}
+	return schema
+}
+
+// applyRule adds the restrictions of the field rule to the schema of the field
+func applyRule(property map[string]interface{}, rule fieldRule) {
+	if rule.operations {
+		property["items"] = map[string]interface{}{
+			"type":    "string",
+			"pattern": fmt.Sprintf("^(%s)(:[^:]+)?$", strings.Join(enumValues(rule), "|")),
+		}
+		return
+	}
+	if len(rule.enum) > 0 {
+		property["enum"] = enumValues(rule)
+	}
+	if rule.min > 0 {
+		property["minimum"] = rule.min
+	}
+	if rule.max > 0 {
+		property["maximum"] = rule.max
+	}
+}
+
+// enumValues lists the allowed values, both lower and upper case ones if the case is ignored
+func enumValues(rule fieldRule) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, value := range rule.enum {
+		variants := []string{value}
+		if rule.ignoreCase {
+			variants = append(variants, strings.ToLower(value), strings.ToUpper(value))
+		}
+		for _, variant := range variants {
+			if !seen[variant] {
+				seen[variant] = true
+				values = append(values, variant)
+			}
+		}
+	}
+	return values
+}
+
+func printSchema() {
+	out, _ := json.MarshalIndent(specSchema(), "", "  ")
+	fmt.Println(string(out))
+}
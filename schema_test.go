@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSpecSchema(t *testing.T) {
+	published, err := ioutil.ReadFile("spec.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.MarshalIndent(specSchema(), "", "  ")
+	if string(published) != string(out)+"\n" {
+		t.Errorf("spec.schema.json is outdated, please run go generate")
+	}
+
+	var schema struct {
+		Definitions map[string]struct {
+			Properties           map[string]map[string]interface{} `json:"properties"`
+			AdditionalProperties bool                              `json:"additionalProperties"`
+			Required             []string                          `json:"required"`
+		} `json:"definitions"`
+	}
+	if err = json.Unmarshal(out, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Topic", "Acl", "Permission", "Resource", "ConsumerGroup", "Connection"} {
+		if _, found := schema.Definitions[name]; !found {
+			t.Errorf("Definition of %s is missing in the schema", name)
+		}
+	}
+	topic := schema.Definitions["Topic"]
+	if topic.AdditionalProperties || topic.Properties["partitions"]["minimum"] != 1.0 || topic.Properties["replication_factor"]["type"] != "integer" {
+		t.Errorf("Wrong schema of Topic: %+v", topic)
+	}
+	if pattern := schema.Definitions["Permission"].Properties["allow_operations"]["items"].(map[string]interface{})["pattern"]; !strings.Contains(pattern.(string), "|DESCRIBE_CONFIGS|") {
+		t.Errorf("Wrong pattern of the operations: %v", pattern)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// redactor is implemented by the structs containing secrets, printResult outputs their redacted copy
+type redactor interface {
+	Redact() interface{}
+}
+
+// resolveSecret returns the secret defined inline, in a file, in Env variable or as stdout of a command.
+// Only one of them can be defined, the empty string is returned if none is
+func resolveSecret(name string, value string, file string, env string, command string) (string, error) {
+	if countTrue(value != "", file != "", env != "", command != "") > 1 {
+		return "", fmt.Errorf("Only one of %s, %s_file, %s_env and %s_command can be defined", name, name, name, name)
+	}
+	switch {
+	case file != "":
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Can't read %s_file: %s", name, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case env != "":
+		val := loadEnvVar(env)
+		if val == "" {
+			return "", fmt.Errorf("Env variable %s from %s_env is not set", env, name)
+		}
+		return val, nil
+	case command != "":
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if err != nil {
+			return "", fmt.Errorf("Command from %s_command failed: %s %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// resolveConnectionSecrets reads the secrets of the connection from the files, Env variables and commands
+func resolveConnectionSecrets(conn Connection) (Connection, error) {
+	for _, s := range []struct {
+		name    string
+		value   *string
+		file    string
+		env     string
+		command string
+	}{
+		{"password", &conn.Password, conn.PasswordFile, conn.PasswordEnv, conn.PasswordCommand},
+		{"tls_key_password", &conn.TLSKeyPassword, conn.TLSKeyPasswordFile, conn.TLSKeyPasswordEnv, conn.TLSKeyPasswordCommand},
+		{"oauth_client_secret", &conn.OAuthClientSecret, conn.OAuthClientSecretFile, conn.OAuthClientSecretEnv, conn.OAuthClientSecretCommand},
+	} {
+		val, err := resolveSecret(s.name, *s.value, s.file, s.env, s.command)
+		if err != nil {
+			return conn, err
+		}
+		*s.value = val
+	}
+	return conn, nil
+}
+
+// Redact returns the copy of the connection without the secret values
+func (c Connection) Redact() interface{} {
+	c.Password = ""
+	c.TLSKeyPassword = ""
+	c.OAuthClientSecret = ""
+	return c
+}
+
+// Redact returns the copy of the user without the password
+func (u User) Redact() interface{} {
+	u.Password = ""
+	return u
+}
+
+// Redact returns the copy of the spec without the secret values
+func (s Spec) Redact() interface{} {
+	s.Connection = s.Connection.Redact().(Connection)
+	users := make([]User, len(s.Users))
+	for i, u := range s.Users {
+		users[i] = u.Redact().(User)
+	}
+	if s.Users != nil {
+		s.Users = users
+	}
+	clusters := make([]Cluster, len(s.Clusters))
+	for i, c := range s.Clusters {
+		c.Connection = c.Connection.Redact().(Connection)
+		clusters[i] = c
+	}
+	if s.Clusters != nil {
+		s.Clusters = clusters
+	}
+	return s
+}
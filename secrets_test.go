@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	file := t.TempDir() + "/secret"
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KAFKA_OPS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("KAFKA_OPS_TEST_SECRET")
+
+	var tests = []struct {
+		value, file, env, command string
+		out                       string
+	}{
+		{"inline", "", "", "", "inline"},
+		{"", file, "", "", "from-file"},
+		{"", "", "KAFKA_OPS_TEST_SECRET", "", "from-env"},
+		{"", "", "", "echo from-command", "from-command"},
+		{"", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		val, err := resolveSecret("password", tt.value, tt.file, tt.env, tt.command)
+		if err != nil || val != tt.out {
+			t.Errorf("resolveSecret failed, expected %s, got %s %v", tt.out, val, err)
+		}
+	}
+
+	for _, args := range [][4]string{
+		{"inline", file, "", ""},
+		{"", "", "KAFKA_OPS_TEST_MISSING", ""},
+		{"", "/nonexistent/secret", "", ""},
+		{"", "", "", "exit 1"},
+	} {
+		_, err := resolveSecret("password", args[0], args[1], args[2], args[3])
+		if err == nil {
+			t.Errorf("resolveSecret must fail for %v", args)
+		}
+	}
+}
+
+func TestPrintResultRedactsSecrets(t *testing.T) {
+	verbose = true
+	defer func() { verbose = false }()
+
+	conn, err := resolveConnectionSecrets(Connection{Broker: "kafka:9092", PasswordCommand: "printf top-%s secret"})
+	if err != nil || conn.Password != "top-secret" {
+		t.Fatalf("resolveConnectionSecrets failed: %v %v", conn, err)
+	}
+	spec := Spec{Connection: conn}
+	out, _ := captureOutput(func() error {
+		printResult(Ok, broker, "", conn)
+		printResult(Ok, broker, "", spec)
+		return nil
+	})
+	if strings.Contains(out, "top-secret") || !strings.Contains(out, "kafka:9092") {
+		t.Fatalf("Secrets must be redacted in the verbose output:\n%s", out)
+	}
+}
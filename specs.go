@@ -0,0 +1,251 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// specSource is the spec read from a single file (or from stdin)
+type specSource struct {
+	path string
+	spec Spec
+}
+
+// stdinSpec keeps the spec read from stdin, as the spec can be parsed several times (e.g. once per cluster)
+var stdinSpec []byte
+
+// expandSpecPaths returns the spec files defined by --spec: the directories are walked recursively
+// for *.yaml, *.yml and *.json files, "-" stands for stdin
+func expandSpecPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if path == "-" {
+			files = append(files, path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && specFormat(file) != "" {
+				found = append(found, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, errors.New("No *.yaml or *.json spec files found in " + path)
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// specFormat detects the format of the spec file by its extension, the empty string is returned if it is unknown
+func specFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return ""
+}
+
+func readSpecSource(path string) ([]byte, error) {
+	if path != "-" {
+		return ioutil.ReadFile(path)
+	}
+	if stdinSpec == nil {
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdinSpec = content
+	}
+	return stdinSpec, nil
+}
+
+// mergeSpecs combines the specs into one. The resources declared more than once must be declared the same way,
+// the conflicting declarations are reported all at once
+func mergeSpecs(sources []specSource) (Spec, error) {
+	var merged Spec
+	var conflicts []string
+	type declaration struct {
+		path  string
+		value interface{}
+	}
+	declared := make(map[string]declaration)
+	// declare remembers the first declaration of the resource and compares the next ones with it.
+	// It returns true for the first declaration only
+	declare := func(kind string, key string, name string, value interface{}, path string) bool {
+		id := kind + "\x00" + key
+		first, found := declared[id]
+		if !found {
+			declared[id] = declaration{path: path, value: value}
+			return true
+		}
+		if diff := describeDiff(first.value, value); diff != "" {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s is declared differently in %s and %s: %s", kind, name, first.path, path, diff))
+		} else if first.path != path {
+			fmt.Fprintf(os.Stderr, "Warning: %s %s is declared in both %s and %s\n", kind, name, first.path, path)
+		}
+		return false
+	}
+
+	for _, source := range sources {
+		spec := source.spec
+		for _, topic := range spec.Topics {
+			normalized := topic
+			if normalized.State == "" {
+				normalized.State = "present"
+			}
+			if declare("Topic", topicKey(topic), topic.Name, normalized, source.path) {
+				merged.Topics = append(merged.Topics, topic)
+			}
+		}
+		for _, acl := range spec.Acls {
+			var permissions []Permission
+			for _, permission := range acl.Permissions {
+				duplicate := true
+				for _, sacl := range expandAcls([]Acl{{Principal: acl.Principal, Permissions: []Permission{permission}}}) {
+					state := sacl.State
+					sacl.State = ""
+					if declare("ACL", aclKey(sacl), sacl.String(), map[string]string{"state": state}, source.path) {
+						duplicate = false
+					}
+				}
+				if !duplicate {
+					permissions = append(permissions, permission)
+				}
+			}
+			if len(permissions) > 0 {
+				merged.Acls = append(merged.Acls, Acl{Principal: acl.Principal, Permissions: permissions})
+			}
+		}
+		for _, group := range spec.ConsumerGroups {
+			if declare("Consumer-group", strings.ToLower(group.PatternType)+":"+group.Name, group.Name, group, source.path) {
+				merged.ConsumerGroups = append(merged.ConsumerGroups, group)
+			}
+		}
+		for _, quota := range spec.Quotas {
+			if declare("Quota", quota.Entity(), quota.Entity(), quota, source.path) {
+				merged.Quotas = append(merged.Quotas, quota)
+			}
+		}
+		for _, user := range spec.Users {
+			if declare("User", user.Name+":"+strings.ToLower(user.Mechanism), user.Name, user, source.path) {
+				merged.Users = append(merged.Users, user)
+			}
+		}
+		if !reflect.DeepEqual(spec.Connection, Connection{}) {
+			if declare("Connection", "", "settings", spec.Connection, source.path) {
+				merged.Connection = spec.Connection
+			}
+		}
+		if spec.Defaults != nil {
+			if declare("Defaults", "", "block", *spec.Defaults, source.path) {
+				merged.Defaults = spec.Defaults
+			}
+		}
+		for _, name := range profileNames(spec) {
+			if declare("Profile", name, name, spec.Profiles[name], source.path) {
+				if merged.Profiles == nil {
+					merged.Profiles = make(map[string]TopicProfile)
+				}
+				merged.Profiles[name] = spec.Profiles[name]
+			}
+		}
+		for _, cluster := range spec.Clusters {
+			if declare("Cluster", cluster.Name, cluster.Name, cluster, source.path) {
+				merged.Clusters = append(merged.Clusters, cluster)
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return merged, errors.New(strings.Join(conflicts, "\n"))
+	}
+	return merged, nil
+}
+
+// describeDiff lists the fields which differ in the two declarations, e.g. "partitions: 3 != 6"
+func describeDiff(a interface{}, b interface{}) string {
+	var fieldsA, fieldsB map[string]interface{}
+	outA, _ := json.Marshal(a)
+	outB, _ := json.Marshal(b)
+	_ = json.Unmarshal(outA, &fieldsA)
+	_ = json.Unmarshal(outB, &fieldsB)
+	keys := make(map[string]bool)
+	for key := range fieldsA {
+		keys[key] = true
+	}
+	for key := range fieldsB {
+		keys[key] = true
+	}
+	var diffs []string
+	for key := range keys {
+		if !reflect.DeepEqual(fieldsA[key], fieldsB[key]) {
+			diffs = append(diffs, fmt.Sprintf("%s: %s != %s", key, diffValue(fieldsA[key]), diffValue(fieldsB[key])))
+		}
+	}
+	sort.Strings(diffs)
+	return strings.Join(diffs, ", ")
+}
+
+func diffValue(val interface{}) string {
+	if val == nil {
+		return "(none)"
+	}
+	out, _ := json.Marshal(val)
+	return strings.Trim(string(out), `"`)
+}
+
+// renderSpec prints the merged spec (of the cluster selected by --cluster) without the secret values
+func renderSpec() error {
+	spec, err := parseSpecFile()
+	if err != nil {
+		return errors.New("Can't parse spec manifest: " + err.Error())
+	}
+	if clusterSelection != "" {
+		names, err := selectClusters(spec, clusterSelection)
+		if err != nil {
+			return err
+		}
+		if len(names) != 1 {
+			return errors.New("Please select a single cluster to render")
+		}
+		spec, err = resolveCluster(spec, names[0])
+		if err != nil {
+			return err
+		}
+	}
+	spec = spec.Redact().(Spec)
+	if isJSON {
+		out, _ := json.MarshalIndent(spec, "", "    ")
+		fmt.Println(string(out))
+	} else {
+		out, _ := yaml.Marshal(spec)
+		fmt.Print(string(out))
+	}
+	return nil
+}
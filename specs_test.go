@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandSpecPaths(t *testing.T) {
+	files, err := expandSpecPaths([]string{"testdata/specs", "-", "testdata/apply_spec.yaml"})
+	expected := "testdata/specs/team-a/topics.yaml,testdata/specs/team-b/topics.json,-,testdata/apply_spec.yaml"
+	if err != nil || strings.Join(files, ",") != expected {
+		t.Errorf("expandSpecPaths failed: %v %v", files, err)
+	}
+	if _, err = expandSpecPaths([]string{"testdata/nonexistent"}); err == nil {
+		t.Errorf("expandSpecPaths must fail for missing path")
+	}
+}
+
+func TestParseSpecFileMerge(t *testing.T) {
+	specFiles = arrFlags{"testdata/specs"}
+	isTemplate = false
+	defer func() { specFiles = nil }()
+
+	var spec Spec
+	out, err := captureOutput(func() (err error) {
+		spec, err = parseSpecFile()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse spec files: %s\n%s", err, out)
+	}
+	if len(spec.Topics) != 2 || spec.Topics[0].Name != "orders" || spec.Topics[1].Name != "payments" || len(spec.Acls) != 1 {
+		t.Errorf("Wrong merged spec: %+v", spec)
+	}
+	if !strings.Contains(out, "Warning: Topic orders is declared in both testdata/specs/team-a/topics.yaml and testdata/specs/team-b/topics.json") {
+		t.Errorf("The duplicate declaration is not reported:\n%s", out)
+	}
+
+	// All the conflicts are reported at once
+	specFiles = arrFlags{"testdata/specs", "testdata/specs_conflict/topics.yaml"}
+	_, err = captureOutput(func() (err error) {
+		_, err = parseSpecFile()
+		return err
+	})
+	expected := []string{
+		"Topic orders is declared differently in testdata/specs/team-a/topics.yaml and testdata/specs_conflict/topics.yaml: partitions: 6 != 12",
+		"Topic payments is declared differently in testdata/specs/team-b/topics.json and testdata/specs_conflict/topics.yaml: partitions: 3 != 0, replication_factor: 2 != 0, state: present != absent",
+		"ACL ALLOW User:team-a@* to WRITE topic:LITERAL:orders is declared differently in testdata/specs/team-a/topics.yaml and testdata/specs_conflict/topics.yaml: state: present != absent",
+	}
+	for _, str := range expected {
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("Conflict \"%s\" is not reported: %v", str, err)
+		}
+	}
+}
+
+func TestRenderSpecStdin(t *testing.T) {
+	stdin, err := ioutil.TempFile(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin.WriteString("topics:\n- name: audit\n  partitions: 1\nconnection:\n  broker: kafka:9092\n  password: secret\n")
+	stdin.Seek(0, 0)
+	savedStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin, stdinSpec, specFiles = savedStdin, nil, nil }()
+
+	specFiles = arrFlags{"testdata/specs/team-b/topics.json", "-"}
+	isTemplate = false
+	out, err := captureOutput(func() error { return renderSpec() })
+	if err != nil {
+		t.Fatalf("Failed to render spec: %s\n%s", err, out)
+	}
+	for _, str := range []string{"- name: payments\n", "- name: orders\n", "- name: audit\n", "broker: kafka:9092"} {
+		if !strings.Contains(out, str) {
+			t.Errorf("Rendered spec does not contain \"%s\":\n%s", str, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Rendered spec must not contain the secrets:\n%s", out)
+	}
+}
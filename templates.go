@@ -0,0 +1,170 @@
+package main
+
+import (
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions available in the spec templates in addition to the built-in ones
+// (printf, index, len, range etc.)
+var templateFuncs = template.FuncMap{
+	"default":  defaultValue,
+	"required": requiredValue,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"replace":  func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":    func(sep string, s string) []string { return strings.Split(s, sep) },
+	"join":     joinValues,
+	"quote":    func(val interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(val)) },
+	"toYaml":   toYaml,
+	"toJson":   toJSON,
+	"indent":   indent,
+	"nindent":  func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+}
+
+// newTemplate creates the spec template with the functions, the missing keys are errors unless --missingok is set
+func newTemplate(name string) *template.Template {
+	t := template.New(name).Funcs(templateFuncs)
+	if !missingOk {
+		t = t.Option("missingkey=error")
+	}
+	return t
+}
+
+// renderTemplate executes the spec template with the values of templateData
+func renderTemplate(content []byte, path string) ([]byte, error) {
+	t, err := newTemplate(path).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	data, err := templateData()
+	if err != nil {
+		return nil, err
+	}
+	var tpl bytes.Buffer
+	err = t.Execute(&tpl, data)
+	if err != nil {
+		return nil, err
+	}
+	return tpl.Bytes(), nil
+}
+
+// templateData returns the template values: the Env variables, the --values files merged in order and
+// the --var variables. Each source takes precedence over the previous ones
+func templateData() (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	for _, item := range os.Environ() {
+		key, val := splitVar(item)
+		data[key] = val
+	}
+	for _, file := range valuesFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]interface{}
+		err = yamlv3.Unmarshal(content, &values)
+		if err != nil {
+			return nil, errors.New("Can't parse values file " + file + ": " + err.Error())
+		}
+		mergeValues(data, values)
+	}
+	for _, item := range varFlags {
+		key, val := splitVar(item)
+		data[key] = val
+	}
+	return data, nil
+}
+
+func splitVar(item string) (string, string) {
+	splits := strings.Split(item, "=")
+	return splits[0], strings.Join(splits[1:], "=")
+}
+
+// mergeValues merges the values into data: the nested maps are merged recursively, all the other values
+// (including the lists) are replaced
+func mergeValues(data map[string]interface{}, values map[string]interface{}) {
+	for key, val := range values {
+		nested, isMap := val.(map[string]interface{})
+		current, wasMap := data[key].(map[string]interface{})
+		if isMap && wasMap {
+			mergeValues(current, nested)
+			continue
+		}
+		data[key] = val
+	}
+}
+
+// defaultValue returns the value unless it is empty (nil, zero, empty string, list or map), e.g. {{ .Env | default "dev" }}
+func defaultValue(def interface{}, val interface{}) interface{} {
+	if isEmptyValue(val) {
+		return def
+	}
+	return val
+}
+
+// requiredValue fails the rendering with the message if the value is empty, e.g. {{ required "Env is required" .Env }}
+func requiredValue(message string, val interface{}) (interface{}, error) {
+	if isEmptyValue(val) {
+		return nil, errors.New(message)
+	}
+	return val, nil
+}
+
+func isEmptyValue(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// joinValues joins the list of any values, e.g. the list from the values file: {{ .brokers | join "," }}
+func joinValues(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expected a list, got %T", list)
+	}
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep), nil
+}
+
+func toYaml(val interface{}) (string, error) {
+	var out bytes.Buffer
+	encoder := yamlv3.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(val); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+func toJSON(val interface{}) (string, error) {
+	out, err := json.Marshal(val)
+	return string(out), err
+}
+
+// indent prefixes every line of the text with the spaces, e.g. for embedding toYaml output into the spec
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSpecFileValues(t *testing.T) {
+	specFiles = arrFlags{"testdata/apply_spec_tenant.yaml"}
+	valuesFiles = arrFlags{"testdata/values/tenant.yaml", "testdata/values/prod.yaml"}
+	isTemplate = true
+	defer func() { specFiles, valuesFiles, varFlags, isTemplate = nil, nil, nil, false }()
+
+	spec, err := parseSpecFile()
+	if err != nil {
+		t.Fatal("Failed to parse spec file: " + err.Error())
+	}
+	expected := []Topic{
+		{Name: "payments.prod.orders", Partitions: 3, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "86400000", "cleanup.policy": "delete"}},
+		{Name: "payments.prod.refunds", Partitions: 6, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "86400000", "cleanup.policy": "delete"}},
+	}
+	if !reflect.DeepEqual(spec.Topics, expected) {
+		t.Errorf("Wrong topics rendered from the values: %+v", spec.Topics)
+	}
+	if len(spec.Acls) != 2 || spec.Acls[0].Principal != "User:payments-api" || strings.Join(spec.Acls[0].Permissions[0].Allow, ",") != "READ,WRITE" ||
+		spec.Acls[1].Permissions[0].Resource.Pattern != "payments.prod." {
+		t.Errorf("Wrong ACLs rendered from the values: %+v", spec.Acls)
+	}
+
+	// --var takes precedence over the values files
+	varFlags = arrFlags{"env=stage"}
+	spec, err = parseSpecFile()
+	if err != nil || spec.Topics[0].Name != "payments.stage.orders" {
+		t.Errorf("--var must override the values: %+v %v", spec.Topics, err)
+	}
+
+	valuesFiles = nil
+	if _, err = parseSpecFile(); err == nil || !strings.Contains(err.Error(), "tenant is not defined in the values") {
+		t.Errorf("parseSpecFile must fail for the required value: %v", err)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	var tests = []struct {
+		template string
+		expected string
+	}{
+		{`{{ .missing | default "dev" }}`, "dev"},
+		{`{{ .name | default "dev" | upper }}`, "KAFKA"},
+		{`{{ "a,b,c" | split "," | join "-" }}`, "a-b-c"},
+		{`{{ .list | join "," }}`, "1,two"},
+		{`{{ .name | quote }}`, `"kafka"`},
+		{`{{ .nested | toYaml | nindent 2 }}`, "\n  a: 1\n  b:\n    - x"},
+		{`{{ .nested | toJson }}`, `{"a":1,"b":["x"]}`},
+		{`{{ "My-Topic " | trim | lower | replace "-" "_" }}`, "my_topic"},
+		{`{{ range $i, $v := .list }}{{ printf "%s-%d " $.name $i }}{{ end }}`, "kafka-0 kafka-1 "},
+	}
+	missingOk = true
+	defer func() { missingOk = false }()
+	data := map[string]interface{}{
+		"name":   "kafka",
+		"list":   []interface{}{1, "two"},
+		"nested": map[string]interface{}{"a": 1, "b": []interface{}{"x"}},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		tmpl, err := newTemplate("test").Parse(tt.template)
+		if err == nil {
+			err = tmpl.Execute(&out, data)
+		}
+		if err != nil || out.String() != tt.expected {
+			t.Errorf("Template %s failed, expected %q, got %q %v", tt.template, tt.expected, out.String(), err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+)
+
+// newTLSConfig builds the TLS settings of the broker connection.
+// The broker certificate is verified against the system roots or --tls-ca unless --tls-insecure is set
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         tlsServerName,
+		InsecureSkipVerify: tlsInsecure,
+	}
+
+	if tlsCA != "" {
+		ca, err := ioutil.ReadFile(tlsCA)
+		if err != nil {
+			return nil, errors.New("Can't read TLS CA: " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("No PEM certificates found in TLS CA " + tlsCA)
+		}
+		config.RootCAs = pool
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		if tlsCert == "" || tlsKey == "" {
+			return nil, errors.New("Both TLS certificate and key must be defined for the client authentication")
+		}
+		cert, err := loadKeyPair(tlsCert, tlsKey, tlsKeyPassword)
+		if err != nil {
+			return nil, errors.New("Can't load TLS client certificate: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// loadKeyPair loads the client certificate and its private key, the key is decrypted if the passphrase is defined
+func loadKeyPair(certFile string, keyFile string, passphrase string) (tls.Certificate, error) {
+	if passphrase == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, errors.New("no PEM data found in " + keyFile)
+	}
+	// Only the legacy PEM encryption (Proc-Type: 4,ENCRYPTED) is supported by the standard library
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+		if err != nil {
+			return tls.Certificate{}, errors.New("can't decrypt " + keyFile + ": " + err.Error())
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate generates the self-signed certificate for 127.0.0.1 and writes it with its key to dir
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-ops-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"kafka.example.local"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := dir + "/cert.pem"
+	keyFile := dir + "/key.pem"
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	defer func() { tlsCA, tlsCert, tlsKey = "", "", "" }()
+
+	tlsCA, tlsCert, tlsKey = certFile, certFile, keyFile
+	config, err := newTLSConfig()
+	if err != nil {
+		t.Fatal("Failed to build TLS config: " + err.Error())
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.InsecureSkipVerify {
+		t.Errorf("Unexpected TLS config: %+v", config)
+	}
+
+	// The encrypted key
+	keyPEM, _ := ioutil.ReadFile(keyFile)
+	block, _ := pem.Decode(keyPEM)
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedFile := t.TempDir() + "/encrypted.pem"
+	if err := ioutil.WriteFile(encryptedFile, pem.EncodeToMemory(encrypted), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tlsKeyPassword = "" }()
+	tlsCA, tlsCert, tlsKey, tlsKeyPassword = "", certFile, encryptedFile, "passphrase"
+	if config, err = newTLSConfig(); err != nil || len(config.Certificates) != 1 {
+		t.Errorf("newTLSConfig failed for the encrypted key: %v", err)
+	}
+	tlsKeyPassword = "wrong"
+	if _, err = newTLSConfig(); err == nil {
+		t.Errorf("newTLSConfig must fail with wrong passphrase")
+	}
+	tlsKeyPassword = ""
+
+	tlsCA, tlsCert, tlsKey = "", certFile, ""
+	if _, err = newTLSConfig(); err == nil {
+		t.Errorf("newTLSConfig must fail when the key is missing")
+	}
+
+	tlsCA, tlsCert, tlsKey = keyFile, "", ""
+	if _, err = newTLSConfig(); err == nil {
+		t.Errorf("newTLSConfig must fail when CA file has no certificates")
+	}
+}
+
+func TestConnectToKafkaClusterTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedBroker := sarama.NewMockBrokerListener(t, 1, listener)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+	})
+
+	protocol = "ssl"
+	broker = seedBroker.Addr()
+	defer func() {
+		protocol = "plaintext"
+		tlsCA, tlsServerName, tlsInsecure = "", "", false
+	}()
+
+	// The certificate is not trusted
+	if _, err = connectToKafkaCluster(); err == nil {
+		t.Fatal("Connection must fail when the broker certificate can't be verified")
+	}
+
+	tlsCA = certFile
+	admin, err := connectToKafkaCluster()
+	if err != nil {
+		t.Fatal("Failed to connect to Kafka cluster: " + err.Error())
+	}
+	(*admin).Close()
+
+	tlsServerName = "other.example.local"
+	if _, err = connectToKafkaCluster(); err == nil {
+		t.Fatal("Connection must fail when the server name does not match")
+	}
+
+	tlsCA, tlsServerName, tlsInsecure = "", "", true
+	admin, err = connectToKafkaCluster()
+	if err != nil {
+		t.Fatal("Failed to connect to Kafka cluster: " + err.Error())
+	}
+	(*admin).Close()
+}
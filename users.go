@@ -0,0 +1,265 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// User describes the SCRAM credential of a user.
+// The password is read from Env variable, from a file or from a command output.
+// The inline password must be the encrypted ENC[...] value
+type User struct {
+	Name            string `yaml:"name" json:"name"`
+	Mechanism       string `yaml:"mechanism,omitempty" json:"mechanism,omitempty"`
+	Iterations      int    `yaml:"iterations,omitempty" json:"iterations,omitempty"`
+	Password        string `yaml:"password,omitempty" json:"password,omitempty"`
+	PasswordEnv     string `yaml:"password_env,omitempty" json:"password_env,omitempty"`
+	PasswordFile    string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+	PasswordCommand string `yaml:"password_command,omitempty" json:"password_command,omitempty"`
+	Rotate          bool   `yaml:"rotate,omitempty" json:"rotate,omitempty"`
+	State           string `yaml:"state,omitempty" json:"state,omitempty"`
+}
+
+// The limits of SCRAM iterations accepted by Kafka
+const (
+	scramMinIterations = 4096
+	scramMaxIterations = 16384
+)
+
+// errResourceNotFound is returned for the users without SCRAM credentials (Errors.RESOURCE_NOT_FOUND, unknown to sarama)
+const errResourceNotFound sarama.KError = 91
+
+func scramMechanismFromString(s string) sarama.ScramMechanismType {
+	switch strings.ToLower(s) {
+	case "scram-sha-256":
+		return sarama.SCRAM_MECHANISM_SHA_256
+	case "scram-sha-512":
+		return sarama.SCRAM_MECHANISM_SHA_512
+	}
+	return sarama.SCRAM_MECHANISM_UNKNOWN
+}
+
+func scramMechanismToString(m sarama.ScramMechanismType) string {
+	switch m {
+	case sarama.SCRAM_MECHANISM_SHA_256:
+		return "scram-sha-256"
+	case sarama.SCRAM_MECHANISM_SHA_512:
+		return "scram-sha-512"
+	}
+	return "unknown"
+}
+
+// listScramUsers returns the current SCRAM credentials, one User per user and mechanism
+func listScramUsers(admin *sarama.ClusterAdmin) ([]User, error) {
+	results, err := (*admin).DescribeUserScramCredentials(nil)
+	if err != nil {
+		return nil, scramError(err)
+	}
+	var users []User
+	for _, result := range results {
+		if result.ErrorCode != sarama.ErrNoError && result.ErrorCode != errResourceNotFound {
+			return nil, scramResultError(result.ErrorCode, result.ErrorMessage)
+		}
+		for _, info := range result.CredentialInfos {
+			users = append(users, User{
+				Name:       result.User,
+				Mechanism:  scramMechanismToString(info.Mechanism),
+				Iterations: int(info.Iterations),
+			})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name == users[j].Name {
+			return users[i].Mechanism < users[j].Mechanism
+		}
+		return users[i].Name < users[j].Name
+	})
+	return users, nil
+}
+
+// normalizeUser applies the defaults and validates the user
+func normalizeUser(user User) (User, error) {
+	user.Mechanism = strings.ToLower(user.Mechanism)
+	if user.Name == "" {
+		return user, errors.New("User name not defined")
+	}
+	if user.State == "absent" {
+		if user.Mechanism != "" && scramMechanismFromString(user.Mechanism) == sarama.SCRAM_MECHANISM_UNKNOWN {
+			return user, errors.New("The only supported user mechanisms: scram-sha-256, scram-sha-512")
+		}
+		return user, nil
+	}
+	if user.State != "" && user.State != "present" {
+		return user, errors.New("Users support only state=absent or state=present")
+	}
+	if user.Mechanism == "" {
+		user.Mechanism = "scram-sha-256"
+	}
+	if scramMechanismFromString(user.Mechanism) == sarama.SCRAM_MECHANISM_UNKNOWN {
+		return user, errors.New("The only supported user mechanisms: scram-sha-256, scram-sha-512")
+	}
+	if user.Iterations == 0 {
+		user.Iterations = scramMinIterations
+	}
+	if user.Iterations < scramMinIterations || user.Iterations > scramMaxIterations {
+		return user, fmt.Errorf("User iterations must be between %d and %d", scramMinIterations, scramMaxIterations)
+	}
+	return user, nil
+}
+
+// userPassword reads the password of the user from the spec, Env variable, a file or a command output
+func userPassword(user User) (string, error) {
+	password, err := resolveSecret("password", user.Password, user.PasswordFile, user.PasswordEnv, user.PasswordCommand)
+	if err != nil {
+		return "", errors.New("Can't read the password of user " + user.Name + ": " + err.Error())
+	}
+	if password == "" {
+		return "", errors.New("Password of user " + user.Name + " not defined, please set password, password_env, password_file or password_command")
+	}
+	return password, nil
+}
+
+// checkInlinePasswords fails if the spec (read before the decryption) has the inline user password in plain text,
+// only the encrypted ENC[...] value can be inline. The syntax errors are left to the validation
+func checkInlinePasswords(content []byte) error {
+	var raw struct {
+		Users []struct {
+			Name     string `yaml:"name"`
+			Password string `yaml:"password"`
+		} `yaml:"users"`
+	}
+	_ = yamlv3.Unmarshal(content, &raw)
+	var errs []string
+	for _, user := range raw.Users {
+		if user.Password != "" && !strings.HasPrefix(strings.TrimSpace(user.Password), encryptedPrefix) {
+			errs = append(errs, "Password of user "+user.Name+" must be encrypted with kafka-ops encrypt or set by password_env, password_file or password_command")
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// alignUser creates, updates or removes the SCRAM credential of the user. In the check mode
+// the password rotation is not a drift, as it is requested on every apply
+func alignUser(admin *sarama.ClusterAdmin, currentUsers []User, user User, check bool) (string, error) {
+	var action string
+	if user.State == "absent" {
+		action = "Remove"
+	} else {
+		action = "Create"
+	}
+	user, err := normalizeUser(user)
+	label := user.Name
+	if user.Mechanism != "" {
+		label += " (" + strings.ToUpper(user.Mechanism) + ")"
+	}
+	fmt.Printf("TASK [USER : %s user %s] %s\n", action, label, strings.Repeat("*", 50))
+	if err != nil {
+		return Error, err
+	}
+
+	if user.State == "absent" {
+		var deletions []sarama.AlterUserScramCredentialsDelete
+		for _, u := range currentUsers {
+			if u.Name == user.Name && (user.Mechanism == "" || u.Mechanism == user.Mechanism) {
+				plan.Add(PlanOperation{Kind: "user", Name: u.Name, Key: u.Mechanism, Action: ActionDelete,
+					Current: fmt.Sprintf("iterations=%d", u.Iterations)})
+				deletions = append(deletions, sarama.AlterUserScramCredentialsDelete{
+					Name:      u.Name,
+					Mechanism: scramMechanismFromString(u.Mechanism),
+				})
+			}
+		}
+		if len(deletions) == 0 {
+			return Ok, nil
+		}
+		if dryRun {
+			return Changed, nil
+		}
+		results, err := (*admin).DeleteUserScramCredentials(deletions)
+		if err != nil {
+			return Error, scramError(err)
+		}
+		return Changed, scramResultsError(results)
+	}
+
+	var current *User
+	for i, u := range currentUsers {
+		if u.Name == user.Name && u.Mechanism == user.Mechanism {
+			current = &currentUsers[i]
+		}
+	}
+	rotate := user.Rotate && !check
+	desired := fmt.Sprintf("iterations=%d", user.Iterations)
+	if current == nil {
+		plan.Add(PlanOperation{Kind: "user", Name: user.Name, Key: user.Mechanism, Action: ActionCreate, Desired: desired})
+	} else if current.Iterations != user.Iterations || rotate {
+		if rotate {
+			desired += ", new password"
+		}
+		plan.Add(PlanOperation{Kind: "user", Name: user.Name, Key: user.Mechanism, Action: ActionAlter,
+			Current: fmt.Sprintf("iterations=%d", current.Iterations), Desired: desired})
+	} else {
+		return Ok, nil
+	}
+
+	// The password is checked also in the dry run, so the plan does not fail on apply
+	password, err := userPassword(user)
+	if err != nil {
+		return Error, err
+	}
+	// The inline password is redacted from the saved plan, so the plan could not be applied
+	if dryRun && planFile != "" && user.Password != "" {
+		return Error, errors.New("Password of user " + user.Name + " is defined inline and can't be saved to the plan file, please set password_env, password_file or password_command")
+	}
+	if dryRun {
+		return Changed, nil
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return Error, err
+	}
+	results, err := (*admin).UpsertUserScramCredentials([]sarama.AlterUserScramCredentialsUpsert{{
+		Name:       user.Name,
+		Mechanism:  scramMechanismFromString(user.Mechanism),
+		Iterations: int32(user.Iterations),
+		Salt:       salt,
+		Password:   []byte(password),
+	}})
+	if err != nil {
+		return Error, scramError(err)
+	}
+	return Changed, scramResultsError(results)
+}
+
+func scramResultsError(results []*sarama.AlterUserScramCredentialsResult) error {
+	for _, result := range results {
+		if result.ErrorCode != sarama.ErrNoError {
+			return scramResultError(result.ErrorCode, result.ErrorMessage)
+		}
+	}
+	return nil
+}
+
+func scramResultError(code sarama.KError, message *string) error {
+	if message != nil && *message != "" {
+		return errors.New(*message)
+	}
+	return code
+}
+
+// scramError adds the hint about --kafka-version if the client refused to send the SCRAM request
+func scramError(err error) error {
+	if errors.Is(err, sarama.ErrUnsupportedVersion) {
+		return errors.New("Kafka 2.7.0 or newer is required for managing users, consider using --kafka-version option")
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUser(t *testing.T) {
+	user, err := normalizeUser(User{Name: "alice"})
+	if err != nil || user.Mechanism != "scram-sha-256" || user.Iterations != 4096 {
+		t.Errorf("normalizeUser failed: %v %v", user, err)
+	}
+
+	for _, user := range []User{
+		{Name: "alice", Mechanism: "plain"},
+		{Name: "alice", Iterations: 100},
+		{Mechanism: "scram-sha-256"},
+		{Name: "alice", State: "deleted"},
+	} {
+		_, err = normalizeUser(user)
+		if err == nil {
+			t.Errorf("normalizeUser must fail for %v", user)
+		}
+	}
+}
+
+func TestApplySpecFileUsers(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"ApiVersionsRequest":     sarama.NewMockApiVersionsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"DescribeUserScramCredentialsRequest": sarama.NewMockWrapper(&sarama.DescribeUserScramCredentialsResponse{
+			Results: []*sarama.DescribeUserScramCredentialsResult{
+				{User: "alice", CredentialInfos: []*sarama.UserScramCredentialsResponseInfo{
+					{Mechanism: sarama.SCRAM_MECHANISM_SHA_512, Iterations: 4096},
+				}},
+				{User: "bob", CredentialInfos: []*sarama.UserScramCredentialsResponseInfo{
+					{Mechanism: sarama.SCRAM_MECHANISM_SHA_256, Iterations: 4096},
+				}},
+				{User: "carol", CredentialInfos: []*sarama.UserScramCredentialsResponseInfo{
+					{Mechanism: sarama.SCRAM_MECHANISM_SHA_256, Iterations: 4096},
+				}},
+				{User: "dave", CredentialInfos: []*sarama.UserScramCredentialsResponseInfo{
+					{Mechanism: sarama.SCRAM_MECHANISM_SHA_256, Iterations: 4096},
+					{Mechanism: sarama.SCRAM_MECHANISM_SHA_512, Iterations: 4096},
+				}},
+			},
+		}),
+		"AlterUserScramCredentialsRequest": sarama.NewMockWrapper(&sarama.AlterUserScramCredentialsResponse{}),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	specFiles = arrFlags{"testdata/apply_spec_users.yaml"}
+	isTemplate = false
+	verbose = true
+	kafkaVersion = "2.7.0"
+	defer func() { kafkaVersion = "2.2.0" }()
+	os.Setenv("ALICE_PASSWORD", "alice-secret")
+	defer os.Unsetenv("ALICE_PASSWORD")
+
+	out, err := captureOutput(func() error { return planSpecFile() })
+	if err != nil {
+		t.Fatalf("Failed to plan spec: %s\n%s", err, out)
+	}
+	expected := [6]string{
+		"[USER : Create user alice (SCRAM-SHA-512)]",
+		"~ alter user alice scram-sha-512: iterations=4096 => iterations=8192",
+		"~ alter user bob scram-sha-256: iterations=4096 => iterations=4096, new password",
+		"- delete user dave scram-sha-256: iterations=4096 => (none)",
+		"- delete user dave scram-sha-512: iterations=4096 => (none)",
+		" to create=0   to alter=2   to delete=2\n",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("Output does not contain expected \"%s\":\n%s", str, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Fatalf("Output must not contain passwords:\n%s", out)
+	}
+
+	out, err = captureOutput(func() error { return applySpecFile() })
+	if err != nil {
+		t.Fatalf("Failed to apply spec: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "ok=1") || !strings.Contains(out, "changed=3") {
+		t.Fatalf("Output does not contain expected \"ok=1\" and \"changed=3\":\n%s", out)
+	}
+
+	// The rotation is not a drift, otherwise --check would report it forever
+	actionCheck = true
+	out, err = captureOutput(func() error {
+		_, err := checkSpecFile()
+		return err
+	})
+	actionCheck = false
+	if err != nil || strings.Contains(out, "user bob scram-sha-256 differs") || !strings.Contains(out, "drifted=3") {
+		t.Fatalf("Rotation must not be reported as a drift: %v\n%s", err, out)
+	}
+
+	// The inline password must be encrypted
+	dir := t.TempDir()
+	specFiles = arrFlags{dir + "/spec.yaml"}
+	if err = ioutil.WriteFile(specFiles[0], []byte("users:\n- name: erin\n  password: erin-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err = captureOutput(func() error { return planSpecFile() })
+	if err == nil || !strings.Contains(err.Error(), "Password of user erin must be encrypted") {
+		t.Fatalf("Plan with the plain text password must fail: %v\n%s", err, out)
+	}
+
+	// The inline password is not saved to the plan file, so such plan is refused
+	encoded, _ := generateEncryptionKey()
+	os.Setenv("KAFKA_OPS_ENCRYPTION_KEY", encoded)
+	defer os.Unsetenv("KAFKA_OPS_ENCRYPTION_KEY")
+	key, _ := loadEncryptionKey("")
+	encrypted, _ := encryptValue(key, "erin-secret")
+	planFile = dir + "/plan.json"
+	defer func() { planFile = "" }()
+	if err = ioutil.WriteFile(specFiles[0], []byte("users:\n- name: erin\n  password: "+encrypted+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err = captureOutput(func() error { return planSpecFile() })
+	if err == nil || !strings.Contains(out, "Password of user erin is defined inline and can't be saved to the plan file") {
+		t.Fatalf("Plan with the inline password must fail: %v\n%s", err, out)
+	}
+	if _, err = os.Stat(planFile); err == nil {
+		t.Errorf("Plan file must not be written")
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// specValidator checks the spec against the Go types before it is unmarshalled, so the errors
+// can point to the line and column of the file. All the errors are collected
+type specValidator struct {
+	path string
+	json bool // the JSON strings must be quoted, unlike the YAML ones
+	errs []string
+}
+
+// fieldRule restricts the value of the spec field. The rules are shared by the validation and the JSON Schema
+type fieldRule struct {
+	enum       []string // the allowed values
+	ignoreCase bool     // the enum is case-insensitive
+	min, max   int      // the range of the integer value, zero stands for no limit
+	operations bool     // the list of ACL rules in OPERATION or OPERATION:host format
+}
+
+// structRules are the requirements to the fields of the spec types, which can't be expressed by the Go types
+type structRules struct {
+	fields   map[string]fieldRule
+	required []string // the fields which must be defined
+	anyOf    []string // at least one of the fields must be defined
+	check    func(v *specValidator, node *yamlv3.Node)
+}
+
+var (
+	aclOperations    = []string{"ANY", "ALL", "READ", "WRITE", "CREATE", "DELETE", "ALTER", "DESCRIBE", "CLUSTER_ACTION", "DESCRIBE_CONFIGS", "ALTER_CONFIGS", "IDEMPOTENT_WRITE"}
+	aclResourceTypes = []string{"any", "topic", "group", "cluster", "transactional-id"}
+	aclPatternTypes  = []string{"LITERAL", "PREFIXED", "MATCH", "ANY"}
+	patternTypes     = []string{"literal", "prefixed", "match"}
+	states           = []string{"present", "absent"}
+	positive         = fieldRule{min: 1}
+)
+
+var specRules = map[reflect.Type]structRules{
+	reflect.TypeOf(Topic{}): {
+		fields: map[string]fieldRule{
+			"state":              {enum: states},
+			"patternType":        {enum: patternTypes, ignoreCase: true},
+			"partitions":         positive,
+			"replication_factor": positive,
+		},
+		required: []string{"name"},
+		check:    checkTopic,
+	},
+	reflect.TypeOf(TopicProfile{}): {
+		fields: map[string]fieldRule{"partitions": positive, "replication_factor": positive},
+	},
+	reflect.TypeOf(ConsumerGroup{}): {
+		fields: map[string]fieldRule{
+			"state":       {enum: states},
+			"patternType": {enum: patternTypes, ignoreCase: true},
+		},
+		required: []string{"name"},
+	},
+	reflect.TypeOf(Acl{}): {
+		required: []string{"principal"},
+	},
+	reflect.TypeOf(Permission{}): {
+		fields: map[string]fieldRule{
+			"state":            {enum: states},
+			"allow_operations": {enum: aclOperations, ignoreCase: true, operations: true},
+			"deny_operations":  {enum: aclOperations, ignoreCase: true, operations: true},
+		},
+		required: []string{"resource"},
+		anyOf:    []string{"allow_operations", "deny_operations"},
+		check:    checkPermission,
+	},
+	reflect.TypeOf(Resource{}): {
+		fields: map[string]fieldRule{
+			"type":        {enum: aclResourceTypes, ignoreCase: true},
+			"patternType": {enum: aclPatternTypes, ignoreCase: true},
+		},
+		required: []string{"type"},
+	},
+	reflect.TypeOf(Quota{}): {
+		fields: map[string]fieldRule{"state": {enum: states}},
+		anyOf:  []string{"user", "client_id"},
+	},
+	reflect.TypeOf(User{}): {
+		fields: map[string]fieldRule{
+			"state":      {enum: states},
+			"mechanism":  {enum: []string{"scram-sha-256", "scram-sha-512"}, ignoreCase: true},
+			"iterations": {min: scramMinIterations, max: scramMaxIterations},
+		},
+		required: []string{"name"},
+	},
+	reflect.TypeOf(Connection{}): {
+		fields: map[string]fieldRule{
+			"protocol":  {enum: []string{"plaintext", "ssl", "sasl_plaintext", "sasl_ssl"}, ignoreCase: true},
+			"mechanism": {enum: []string{"scram-sha-256", "scram-sha-512", "plain", "gssapi", "oauthbearer"}, ignoreCase: true},
+		},
+	},
+	reflect.TypeOf(Cluster{}): {
+		required: []string{"name"},
+	},
+	reflect.TypeOf(TopicPatch{}): {
+		fields: map[string]fieldRule{
+			"state":              {enum: states},
+			"partitions":         positive,
+			"replication_factor": positive,
+		},
+		required: []string{"name"},
+	},
+	reflect.TypeOf(Generator{}): {
+		required: []string{"dimensions"},
+		anyOf:    []string{"topics", "acls"},
+	},
+}
+
+// validateSpecFiles checks the spec files without connecting to the cluster: the fields and values of every file,
+// the conflicts between the files, the profiles and the overrides of the clusters
+func validateSpecFiles() error {
+	paths, err := expandSpecPaths(specFiles)
+	if err != nil {
+		return err
+	}
+	spec, err := parseSpecFile()
+	if err != nil {
+		return errors.New("Invalid spec manifest: " + err.Error())
+	}
+	names := []string{""}
+	if len(spec.Clusters) > 0 {
+		selection := clusterSelection
+		if selection == "" {
+			selection = "all"
+		}
+		names, err = selectClusters(spec, selection)
+		if err != nil {
+			return errors.New("Invalid spec manifest: " + err.Error())
+		}
+	}
+	var errs []string
+	for _, name := range names {
+		if _, err = resolveCluster(spec, name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("Invalid spec manifest: " + strings.Join(errs, "\n"))
+	}
+	for _, path := range paths {
+		fmt.Printf("%sok%s: [%s]\n", Ok, Default, path)
+	}
+	return nil
+}
+
+// specError lists all the problems found in the spec file, each one prefixed by path:line:column (or path:line for the YAML syntax errors)
+type specError struct {
+	errs []string
+}
+
+func (e *specError) Error() string {
+	return strings.Join(e.errs, "\n")
+}
+
+// validateSpec checks the spec file content: the syntax, unknown keys, value types and enums
+func validateSpec(content []byte, path string, format string) error {
+	return validateDocument(content, path, format, reflect.TypeOf(Spec{}))
+}
+
+// validateDocument checks the content of the spec or overlay file against the root type
+func validateDocument(content []byte, path string, format string, root reflect.Type) error {
+	if format == "json" {
+		if err := jsonSyntaxError(content, path); err != nil {
+			return err
+		}
+	}
+	var document yamlv3.Node
+	err := yamlv3.Unmarshal(content, &document)
+	if err != nil && format == "json" {
+		// The valid JSON which is not valid YAML: the unknown keys are reported without the position
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(reflect.New(root).Interface()); err != nil {
+			return &specError{errs: []string{path + ": " + err.Error()}}
+		}
+		return nil
+	}
+	if err != nil {
+		return &specError{errs: []string{yamlSyntaxError(err, path)}}
+	}
+	if len(document.Content) == 0 {
+		return &specError{errs: []string{path + ": the document is empty"}}
+	}
+	v := &specValidator{path: path, json: format == "json"}
+	v.walk(document.Content[0], root)
+	if len(v.errs) > 0 {
+		return &specError{errs: v.errs}
+	}
+	return nil
+}
+
+var yamlErrorRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// yamlSyntaxError formats the YAML syntax error as path:line, yaml.v3 reports only the line of the error
+func yamlSyntaxError(err error, path string) string {
+	if match := yamlErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+		return fmt.Sprintf("%s:%s: %s", path, match[1], match[2])
+	}
+	return path + ": " + err.Error()
+}
+
+// jsonSyntaxError returns the JSON syntax error with the line and column
+func jsonSyntaxError(content []byte, path string) error {
+	var val interface{}
+	err := json.Unmarshal(content, &val)
+	syntaxErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return nil
+	}
+	// The offset is the one of the byte after the error, it is 0 for the empty content
+	offset := int(syntaxErr.Offset) - 1
+	if offset < 0 {
+		offset = 0
+	}
+	line, column := 1, 1
+	for _, c := range content[:offset] {
+		if c == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	return &specError{errs: []string{fmt.Sprintf("%s:%d:%d: %s", path, line, column, err.Error())}}
+}
+
+func (v *specValidator) errorf(node *yamlv3.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Sprintf("%s:%d:%d: %s", v.path, node.Line, node.Column, fmt.Sprintf(format, args...)))
+}
+
+// walk checks that the node can be unmarshalled to the type
+func (v *specValidator) walk(node *yamlv3.Node, t reflect.Type) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if !v.expect(node, yamlv3.MappingNode, "mapping") {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				v.walk(value, t)
+				continue
+			}
+			field, found := fields[key.Value]
+			if !found {
+				v.errorf(key, "unknown field %q in %s%s", key.Value, t.Name(), suggestField(key.Value, fields))
+				continue
+			}
+			v.walk(value, field.Type)
+			if rule, found := specRules[t].fields[key.Value]; found {
+				v.checkRule(value, key.Value, rule)
+			}
+		}
+		v.checkStruct(node, t)
+	case reflect.Map:
+		if !v.expect(node, yamlv3.MappingNode, "mapping") {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.walk(node.Content[i], t.Key())
+			if t == reflect.TypeOf(TopicConfigs{}) {
+				// The config values are converted to strings
+				v.expect(node.Content[i+1], yamlv3.ScalarNode, "string, number or boolean")
+				continue
+			}
+			v.walk(node.Content[i+1], t.Elem())
+		}
+	case reflect.Slice:
+		if !v.expect(node, yamlv3.SequenceNode, "list") {
+			return
+		}
+		for _, item := range node.Content {
+			v.walk(item, t.Elem())
+		}
+	case reflect.String:
+		if v.expect(node, yamlv3.ScalarNode, "string") && v.json && node.Tag != "!!str" {
+			v.errorf(node, "expected string, got %s", node.Value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.expect(node, yamlv3.ScalarNode, "integer") && node.Tag != "!!int" {
+			v.errorf(node, "expected integer, got %q", node.Value)
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.expect(node, yamlv3.ScalarNode, "number") && node.Tag != "!!int" && node.Tag != "!!float" {
+			v.errorf(node, "expected number, got %q", node.Value)
+		}
+	case reflect.Bool:
+		if v.expect(node, yamlv3.ScalarNode, "boolean") && node.Tag != "!!bool" {
+			v.errorf(node, "expected boolean, got %q", node.Value)
+		}
+	}
+}
+
+func (v *specValidator) expect(node *yamlv3.Node, kind yamlv3.Kind, name string) bool {
+	if node.Kind == kind {
+		return true
+	}
+	got := map[yamlv3.Kind]string{yamlv3.MappingNode: "mapping", yamlv3.SequenceNode: "list", yamlv3.ScalarNode: "scalar"}[node.Kind]
+	v.errorf(node, "expected %s, got %s", name, got)
+	return false
+}
+
+// yamlFields maps the YAML keys of the struct to its fields
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		// Matched lists the resources deleted by the pattern, it is the output only and is not read from the spec
+		if name == "-" || field.Name == "Matched" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// suggestField returns the hint about the known field similar to the unknown one, e.g. replicationFactor => replication_factor
+func suggestField(name string, fields map[string]reflect.StructField) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(s))
+	}
+	var known []string
+	for field := range fields {
+		known = append(known, field)
+	}
+	sort.Strings(known)
+	for _, field := range known {
+		if levenshtein(normalize(name), normalize(field)) <= 2 {
+			return fmt.Sprintf(", did you mean %q?", field)
+		}
+	}
+	return ""
+}
+
+func levenshtein(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// field returns the value node of the key in the mapping node, nil if it is not defined
+func field(node *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			if value.Kind == yamlv3.AliasNode {
+				value = value.Alias
+			}
+			if value.Kind == yamlv3.ScalarNode && value.Tag == "!!null" {
+				return nil
+			}
+			return value
+		}
+	}
+	return nil
+}
+
+// checkRule checks the value of the field against its rule
+func (v *specValidator) checkRule(node *yamlv3.Node, name string, rule fieldRule) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if rule.operations {
+		if node.Kind == yamlv3.SequenceNode {
+			for _, item := range node.Content {
+				v.checkOperation(item, rule.enum)
+			}
+		}
+		return
+	}
+	if node.Kind != yamlv3.ScalarNode || node.Tag == "!!null" {
+		return
+	}
+	if len(rule.enum) > 0 && !inEnum(node.Value, rule) {
+		v.errorf(node, "%s must be one of %s, got %q", name, strings.Join(rule.enum, ", "), node.Value)
+	}
+	n, err := strconv.Atoi(node.Value)
+	if err != nil || node.Tag != "!!int" {
+		return
+	}
+	if rule.max > 0 && (n < rule.min || n > rule.max) {
+		v.errorf(node, "%s must be between %d and %d, got %d", name, rule.min, rule.max, n)
+	} else if rule.min > 0 && n < rule.min {
+		v.errorf(node, "%s must be at least %d, got %d", name, rule.min, n)
+	}
+}
+
+func inEnum(value string, rule fieldRule) bool {
+	for _, allowed := range rule.enum {
+		if value == allowed || (rule.ignoreCase && strings.EqualFold(value, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOperation checks the OPERATION or OPERATION:host format of the ACL rule
+func (v *specValidator) checkOperation(rule *yamlv3.Node, operations []string) {
+	if rule.Kind != yamlv3.ScalarNode {
+		return
+	}
+	parts := strings.Split(rule.Value, ":")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] == "") {
+		v.errorf(rule, "operation must be in OPERATION or OPERATION:host format, got %q", rule.Value)
+		return
+	}
+	if !inEnum(parts[0], fieldRule{enum: operations, ignoreCase: true}) {
+		v.errorf(rule, "operation must be one of %s, got %q", strings.Join(operations, ", "), parts[0])
+	}
+}
+
+// checkStruct checks the required fields of the struct and runs its own check
+func (v *specValidator) checkStruct(node *yamlv3.Node, t reflect.Type) {
+	rules := specRules[t]
+	for _, name := range rules.required {
+		if value := field(node, name); value == nil || (value.Kind == yamlv3.ScalarNode && value.Value == "") {
+			v.errorf(node, "%s is not defined in %s", name, t.Name())
+		}
+	}
+	if len(rules.anyOf) > 0 {
+		found := false
+		for _, name := range rules.anyOf {
+			found = found || field(node, name) != nil
+		}
+		if !found {
+			v.errorf(node, "%s must define %s", t.Name(), strings.Join(rules.anyOf, " or "))
+		}
+	}
+	if rules.check != nil {
+		rules.check(v, node)
+	}
+}
+
+func isAbsent(node *yamlv3.Node) bool {
+	state := field(node, "state")
+	return state != nil && state.Value == "absent"
+}
+
+func checkTopic(v *specValidator, node *yamlv3.Node) {
+	if patternType := field(node, "patternType"); patternType != nil && !isAbsent(node) && !strings.EqualFold(patternType.Value, "literal") {
+		v.errorf(patternType, "patternType %s can be used only with state: absent", patternType.Value)
+	}
+}
+
+// checkPermission rejects the filters matching several ACLs unless they are used to delete them
+func checkPermission(v *specValidator, node *yamlv3.Node) {
+	if isAbsent(node) {
+		return
+	}
+	if resource := field(node, "resource"); resource != nil && resource.Kind == yamlv3.MappingNode {
+		for _, key := range []string{"type", "patternType"} {
+			value := field(resource, key)
+			if value != nil && (strings.EqualFold(value.Value, "any") || strings.EqualFold(value.Value, "match")) {
+				v.errorf(value, "%s %s can be used only with state: absent", key, value.Value)
+			}
+		}
+	}
+	for _, key := range []string{"allow_operations", "deny_operations"} {
+		rules := field(node, key)
+		if rules == nil || rules.Kind != yamlv3.SequenceNode {
+			continue
+		}
+		for _, rule := range rules.Content {
+			if strings.EqualFold(strings.Split(rule.Value, ":")[0], "any") {
+				v.errorf(rule, "operation ANY can be used only with state: absent")
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseSpecFileValidation(t *testing.T) {
+	specFiles = arrFlags{"testdata/validate_spec_errors.yaml", "testdata/specs"}
+	isTemplate = false
+	defer func() { specFiles = nil }()
+
+	_, err := parseSpecFile()
+	if err == nil {
+		t.Fatal("parseSpecFile must fail for the invalid spec")
+	}
+	// All the errors are reported at once
+	expected := []string{
+		`testdata/validate_spec_errors.yaml:4:15: partitions must be at least 1, got 0`,
+		`testdata/validate_spec_errors.yaml:5:3: unknown field "replicationFactor" in Topic, did you mean "replication_factor"?`,
+		`testdata/validate_spec_errors.yaml:7:15: expected integer, got "three"`,
+		`testdata/validate_spec_errors.yaml:8:10: state must be one of present, absent, got "deleted"`,
+		`testdata/validate_spec_errors.yaml:13:13: type must be one of any, topic, group, cluster, transactional-id, got "topics"`,
+		`testdata/validate_spec_errors.yaml:16:5: unknown field "allow_operation" in Permission, did you mean "allow_operations"?`,
+		`testdata/validate_spec_errors.yaml:12:5: Permission must define allow_operations or deny_operations`,
+		`testdata/validate_spec_errors.yaml:21:24: operation must be one of ANY, ALL, READ, WRITE`,
+		`testdata/validate_spec_errors.yaml:21:34: operation must be in OPERATION or OPERATION:host format, got "WRITE:"`,
+		`testdata/validate_spec_errors.yaml:22:12: state must be one of present, absent, got "removed"`,
+	}
+	for _, str := range expected {
+		if !strings.Contains(err.Error(), str) {
+			t.Errorf("Error \"%s\" is not reported:\n%v", str, err)
+		}
+	}
+	if strings.Contains(err.Error(), "testdata/specs/") {
+		t.Errorf("The valid spec files must not be reported:\n%v", err)
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	var tests = []struct {
+		spec   string
+		format string
+		err    string
+	}{
+		{"topics:\n- name: a\n  patternType: prefixed\n  state: absent\n", "", ""},
+		{"topics:\n- name: a\n  patternType: prefixed\n", "", "spec.yaml:3:16: patternType prefixed can be used only with state: absent"},
+		{"acls:\n- principal: 'User:*'\n  permissions:\n  - resource: {type: any, pattern: a, patternType: MATCH}\n    allow_operations: [ANY]\n    state: absent\n", "", ""},
+		{"acls:\n- principal: 'User:*'\n  permissions:\n  - resource: {type: topic, pattern: a, patternType: MATCH}\n    allow_operations: [READ]\n", "", "spec.yaml:4:54: patternType MATCH can be used only with state: absent"},
+		{"users:\n- name: bob\n  mechanism: scram-sha-1\n  iterations: 100\n", "", "spec.yaml:3:14: mechanism must be one of scram-sha-256, scram-sha-512"},
+		{"connection:\n  protocol: tls\n", "", `spec.yaml:2:13: protocol must be one of plaintext, ssl, sasl_plaintext, sasl_ssl, got "tls"`},
+		{"defaults:\n  partitions: -1\nprofiles:\n  small: {partition: 1}\n", "", `spec.yaml:4:11: unknown field "partition" in TopicProfile, did you mean "partitions"?`},
+		{"topics:\n- partitions: 1\nquotas:\n- producer_byte_rate: 1024\n", "", "spec.yaml:2:3: name is not defined in Topic"},
+		{"quotas:\n- producer_byte_rate: 1024\n", "", "spec.yaml:2:3: Quota must define user or client_id"},
+		{"topics:\n- name: a\n  configs: {retention.ms: 86400000, preallocate: true}\n", "", ""},
+		{"topics:\n- name: a\n  state: absent\n  matched: [b]\n", "", `spec.yaml:4:3: unknown field "matched" in Topic`},
+		{"users:\n- name: bob\n  iterations: 100\n", "", "spec.yaml:3:15: iterations must be between 4096 and 16384, got 100"},
+		{"acls:\n- principal: 'User:*'\n  permissions:\n  - resource: {type: topic, pattern: a}\n    deny_operations: ['any:*']\n", "", "spec.yaml:5:23: operation ANY can be used only with state: absent"},
+		{"topics: [\n", "", "spec.yaml:1: did not find expected node content"},
+		{"topics:\n- name: a\n partitions: 1\n", "", "spec.yaml:2: did not find expected key"},
+		{"", "json", "spec.yaml:1:1: unexpected end of JSON input"},
+		{"", "", "spec.yaml: the document is empty"},
+		{"# no topics yet\n", "", "spec.yaml: the document is empty"},
+		{"{\n\t\"topics\": [{\"name\": \"a\", \"partitions\": 1}]\n}\n", "json", ""},
+		{"{\n\t\"topics\": [{\"name\": \"a\", \"partitions\": 1,}]\n}\n", "json", "spec.yaml:2:43: invalid character"},
+		{"{\n\t\"topics\": [{\"name\": \"a\", \"partition\": 1}]\n}\n", "json", `spec.yaml:2:27: unknown field "partition" in Topic`},
+		{"{\"topics\": [{\"name\": \"a\", \"configs\": {\"retention.ms\": 86400000, \"preallocate\": true}}]}", "json", ""},
+		{"{\"topics\": [{\"name\": 5}]}", "json", "spec.yaml:1:22: expected string, got 5"},
+	}
+	for _, tt := range tests {
+		err := validateSpec([]byte(tt.spec), "spec.yaml", tt.format)
+		if tt.err == "" && err != nil {
+			t.Errorf("validateSpec failed for %q: %v", tt.spec, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("validateSpec must fail for %q with %q, got %v", tt.spec, tt.err, err)
+		}
+	}
+}
+
+func TestValidateSpecFiles(t *testing.T) {
+	specFiles = arrFlags{"testdata/specs", "testdata/apply_spec_profiles.yaml"}
+	isTemplate = false
+	defer func() { specFiles = nil }()
+
+	out, err := captureOutput(validateSpecFiles)
+	if err != nil || !strings.Contains(out, "ok\033[0m: [testdata/specs/team-b/topics.json]") || !strings.Contains(out, "ok\033[0m: [testdata/apply_spec_profiles.yaml]") {
+		t.Errorf("validateSpecFiles failed: %v\n%s", err, out)
+	}
+
+	// The conflicts between the files are semantic errors too
+	specFiles = arrFlags{"testdata/specs", "testdata/specs_conflict/topics.yaml"}
+	_, err = captureOutput(validateSpecFiles)
+	if err == nil || !strings.Contains(err.Error(), "Topic orders is declared differently") {
+		t.Errorf("validateSpecFiles must fail for the conflicting specs: %v", err)
+	}
+
+	specFiles = arrFlags{t.TempDir() + "/spec.yaml"}
+	clusterSelection = "prod,stage"
+	defer func() { clusterSelection = "" }()
+	content := "clusters:\n- name: prod\n"
+	if err = ioutil.WriteFile(specFiles[0], []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = captureOutput(validateSpecFiles)
+	if err == nil || !strings.Contains(err.Error(), "Cluster stage is not defined in the spec") {
+		t.Errorf("validateSpecFiles must fail for the unknown cluster: %v", err)
+	}
+}
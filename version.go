@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// clusterVersion is the Kafka version the client talks to the cluster with, either defined or detected
+var clusterVersion = sarama.V2_2_0_0
+
+// kafkaVersionMarkers are the APIs (with their max version) the brokers support since the Kafka version, the newest first
+var kafkaVersionMarkers = []struct {
+	version    sarama.KafkaVersion
+	apiKey     int16
+	maxVersion int16
+}{
+	{sarama.V3_0_0_0, 65, 0},  // DescribeTransactions
+	{sarama.V2_8_0_0, 60, 0},  // DescribeCluster
+	{sarama.V2_7_0_0, 50, 0},  // DescribeUserScramCredentials
+	{sarama.V2_6_0_0, 48, 0},  // DescribeClientQuotas
+	{sarama.V2_4_0_0, 45, 0},  // AlterPartitionReassignments
+	{sarama.V2_3_0_0, 44, 0},  // IncrementalAlterConfigs
+	{sarama.V2_2_0_0, 43, 0},  // ElectLeaders
+	{sarama.V2_1_0_0, 0, 7},   // Produce v7
+	{sarama.V2_0_0_0, 3, 6},   // Metadata v6
+	{sarama.V1_1_0_0, 42, 0},  // DeleteGroups
+	{sarama.V1_0_0_0, 3, 5},   // Metadata v5
+	{sarama.V0_11_0_0, 30, 0}, // CreateAcls
+	{sarama.V0_10_1_0, 19, 0}, // CreateTopics
+}
+
+// versionFromApiVersions returns the highest Kafka version whose APIs are all supported by the broker
+func versionFromApiVersions(apiKeys []sarama.ApiVersionsResponseKey) sarama.KafkaVersion {
+	supported := make(map[int16]int16)
+	for _, key := range apiKeys {
+		supported[key.ApiKey] = key.MaxVersion
+	}
+	for _, marker := range kafkaVersionMarkers {
+		if maxVersion, found := supported[marker.apiKey]; found && maxVersion >= marker.maxVersion {
+			return marker.version
+		}
+	}
+	return sarama.V0_10_0_0
+}
+
+// detectKafkaVersion asks the first reachable broker for its ApiVersions
+func detectKafkaVersion(brokerAddrs []string, config *sarama.Config) (sarama.KafkaVersion, error) {
+	// ApiVersions is available since 0.10.0, 1.0.0 is needed for SASL handshake v1
+	probe := *config
+	probe.Version = sarama.V1_0_0_0
+	var lastErr error
+	for _, addr := range brokerAddrs {
+		b := sarama.NewBroker(addr)
+		err := b.Open(&probe)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		res, err := b.ApiVersions(&sarama.ApiVersionsRequest{})
+		_ = b.Close()
+		if err == nil && res.ErrorCode != int16(sarama.ErrNoError) {
+			err = sarama.KError(res.ErrorCode)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return versionFromApiVersions(res.ApiKeys), nil
+	}
+	return sarama.KafkaVersion{}, errors.New("Can't detect Kafka version, consider using --kafka-version option: " + lastErr.Error())
+}
+
+// requireKafkaVersion fails if the cluster is older than the version the feature needs
+func requireKafkaVersion(feature string, version sarama.KafkaVersion) error {
+	if clusterVersion.IsAtLeast(version) {
+		return nil
+	}
+	return fmt.Errorf("Kafka %s or newer is required for %s, the cluster version is %s", version, feature, clusterVersion)
+}
+
+// checkSpecFeatures fails before any change if the spec has the resources the cluster version can't manage
+func checkSpecFeatures(spec Spec) error {
+	var errs []string
+	if len(spec.Quotas) > 0 {
+		if err := requireKafkaVersion("managing quotas", sarama.V2_6_0_0); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(spec.Users) > 0 {
+		if err := requireKafkaVersion("managing users", sarama.V2_7_0_0); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
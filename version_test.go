@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+
+	"strings"
+	"testing"
+)
+
+func TestVersionFromApiVersions(t *testing.T) {
+	var tests = []struct {
+		apiKeys []sarama.ApiVersionsResponseKey
+		version sarama.KafkaVersion
+	}{
+		{nil, sarama.V0_10_0_0},
+		{[]sarama.ApiVersionsResponseKey{{ApiKey: 0, MaxVersion: 8}, {ApiKey: 1, MaxVersion: 11}}, sarama.V2_1_0_0},
+		{[]sarama.ApiVersionsResponseKey{{ApiKey: 3, MaxVersion: 5}, {ApiKey: 30, MaxVersion: 1}}, sarama.V1_0_0_0},
+		{[]sarama.ApiVersionsResponseKey{{ApiKey: 44, MaxVersion: 1}, {ApiKey: 45, MaxVersion: 0}}, sarama.V2_4_0_0},
+		{[]sarama.ApiVersionsResponseKey{{ApiKey: 48, MaxVersion: 1}, {ApiKey: 50, MaxVersion: 0}}, sarama.V2_7_0_0},
+		{[]sarama.ApiVersionsResponseKey{{ApiKey: 65, MaxVersion: 0}}, sarama.V3_0_0_0},
+	}
+	for _, tt := range tests {
+		if v := versionFromApiVersions(tt.apiKeys); v != tt.version {
+			t.Errorf("versionFromApiVersions failed for %v, expected %s, got %s", tt.apiKeys, tt.version, v)
+		}
+	}
+}
+
+func TestConnectToKafkaClusterAutoVersion(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t).SetApiKeys([]sarama.ApiVersionsResponseKey{
+			{ApiKey: 3, MaxVersion: 9},
+			{ApiKey: 44, MaxVersion: 1},
+			{ApiKey: 45, MaxVersion: 0},
+			{ApiKey: 48, MaxVersion: 1},
+		}),
+	})
+
+	protocol = "plaintext"
+	broker = seedBroker.Addr()
+	kafkaVersion = "auto"
+	defer func() { kafkaVersion = "2.2.0" }()
+
+	admin, err := connectToKafkaCluster()
+	if err != nil {
+		t.Fatal("Failed to connect to Kafka cluster: " + err.Error())
+	}
+	(*admin).Close()
+	if clusterVersion != sarama.V2_6_0_0 {
+		t.Errorf("Detected wrong Kafka version %s", clusterVersion)
+	}
+
+	// The users are refused before any change
+	specFiles = arrFlags{"testdata/apply_spec_users.yaml"}
+	isTemplate = false
+	out, err := captureOutput(func() error { return applySpecFile() })
+	if err == nil || !strings.Contains(err.Error(), "Kafka 2.7.0 or newer is required for managing users, the cluster version is 2.6.0") {
+		t.Errorf("applySpecFile must fail for the users with Kafka 2.6.0: %v\n%s", err, out)
+	}
+}